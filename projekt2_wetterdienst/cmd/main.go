@@ -11,6 +11,7 @@ import (
 	"github.com/risingwavelabs/eris"
 
 	"weather-service/internal/config"
+	"weather-service/internal/logging"
 	"weather-service/internal/server"
 	"weather-service/internal/services"
 	"weather-service/internal/station"
@@ -40,21 +41,33 @@ func run(ctx context.Context) error {
 	if err != nil {
 		return eris.Wrap(err, "error while loading config")
 	}
-	config.C.Print()
+
+	err = logging.Configure(config.C.Logging)
+	if err != nil {
+		return eris.Wrap(err, "error while configuring logging")
+	}
+
+	// The multi-line output would break the records of structured logs.
+	if config.C.Logging.Format != "json" {
+		config.C.Print()
+	}
 
 	//
 	// Run services.
 
+	streamer := server.NewStreamer()
+	registry := &services.Registry{}
+
 	svcList := []services.Service{
 		// List services here.
-		&server.Server{},
-		&server.Streamer{},
+		&server.Server{Broker: streamer, Services: registry},
+		streamer,
 	}
 	for _, city := range config.C.Cities {
 		svcList = append(svcList, station.City(city))
 	}
 
-	err = services.Run(ctx, svcList)
+	err = services.Run(ctx, svcList, registry)
 	if err != nil {
 		return eris.Wrap(err, "error while running services")
 	}
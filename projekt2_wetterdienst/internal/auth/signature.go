@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers used to authenticate requests of stations.
+const (
+	HeaderStation   = "X-Station-ID"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderSignature = "X-Signature"
+)
+
+// Signature computes the HMAC-SHA256 of a request. It covers the method, the
+// path with query, the timestamp in Unix seconds, and the hash of the body.
+func Signature(secret []byte, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds the headers which authenticate the request as the given station.
+// The body must be the one sent with the request.
+func Sign(req *http.Request, body []byte, stationID string, secret []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req.Header.Set(HeaderStation, stationID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Signature(secret, req.Method, req.URL.RequestURI(), timestamp, body))
+}
+
+// Verify checks the signature of a request. Requests older or newer than
+// `maxSkew` are rejected to limit replays.
+func Verify(r *http.Request, body []byte, secret []byte, now time.Time, maxSkew time.Duration) error {
+	timestamp := r.Header.Get(HeaderTimestamp)
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("timestamp differs by %s from server time", skew.Round(time.Second))
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	expected, _ := hex.DecodeString(Signature(secret, r.Method, r.URL.RequestURI(), timestamp, body))
+	if !hmac.Equal(signature, expected) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that signed requests are verified and that changes to any signed
+// part of the request are detected.
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	body := []byte(`{"temp":21}`)
+	now := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+
+	newRequest := func() *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost/cities/München", nil)
+		require.NoError(t, err)
+		Sign(req, body, "station", secret, now)
+		return req
+	}
+
+	req := newRequest()
+	require.Equal(t, "station", req.Header.Get(HeaderStation))
+	require.NoError(t, Verify(req, body, secret, now.Add(time.Minute), 5*time.Minute))
+
+	// Wrong secret.
+	require.Error(t, Verify(req, body, []byte("other"), now, 5*time.Minute))
+
+	// Tampered body.
+	require.Error(t, Verify(req, []byte(`{"temp":22}`), secret, now, 5*time.Minute))
+
+	// Tampered path.
+	req = newRequest()
+	req.URL.Path = "/cities/Berlin"
+	require.Error(t, Verify(req, body, secret, now, 5*time.Minute))
+
+	// Outdated request.
+	req = newRequest()
+	err := Verify(req, body, secret, now.Add(10*time.Minute), 5*time.Minute)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(), "timestamp"))
+}
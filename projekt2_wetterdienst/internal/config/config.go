@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/risingwavelabs/eris"
 	"gopkg.in/yaml.v3"
@@ -14,8 +16,62 @@ var C = Config{
 	// Initialize the default config here.
 
 	APIPort: 8080,
+
+	Logging: LoggingConfig{
+		Level:  "info",
+		Format: "text",
+	},
+
+	StaleAfter: time.Minute,
+
+	Storage: StorageConfig{
+		CompactInterval: 5 * time.Minute,
+	},
+
+	TLS: TLSConfig{
+		ReloadInterval: 10 * time.Second,
+	},
+
+	Stream: StreamConfig{
+		ReplayBuffer:      256,
+		HeartbeatInterval: 15 * time.Second,
+		RetryInterval:     3 * time.Second,
+		Overflow:          OverflowDropNewest,
+		MaxDrops:          64,
+		PostQueue:         256,
+		PostTimeout:       time.Second,
+		Shards:            0,
+	},
+
+	Auth: AuthConfig{
+		MaxSkew: 5 * time.Minute,
+	},
+
+	Validation: ValidationConfig{
+		Temperature:   Range{-90, 60},
+		Humidity:      Range{0, 100},
+		Pressure:      Range{850, 1090},
+		WindSpeed:     Range{0, 120},
+		WindDirection: Range{0, 360},
+		Precipitation: Range{0, 500},
+		MaxClockSkew:  time.Minute,
+		UnknownCities: UnknownCitiesReject,
+	},
 }
 
+// Policies for events of subscribers which do not keep up.
+const (
+	OverflowDropNewest = "drop-newest"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDisconnect = "disconnect"
+)
+
+// Policies for measurements of cities which are not configured.
+const (
+	UnknownCitiesReject = "reject"
+	UnknownCitiesCreate = "create"
+)
+
 type Config struct {
 	// Define config structure here.
 	// Do not forget the `yaml` or `json` tags.
@@ -23,7 +79,249 @@ type Config struct {
 	// Port used for the API server.
 	APIPort uint16 `yaml:"apiPort"`
 
+	TLS TLSConfig `yaml:"tls"`
+
+	Logging LoggingConfig `yaml:"logging"`
+
+	Cities []string `yaml:"cities"`
+
+	// Cities without measurements for this long are reported as stale.
+	StaleAfter time.Duration `yaml:"staleAfter"`
+
+	Storage StorageConfig `yaml:"storage"`
+
+	Validation ValidationConfig `yaml:"validation"`
+
+	Auth AuthConfig `yaml:"auth"`
+
+	RateLimits []RateLimitConfig `yaml:"rateLimits"`
+
+	OpenAPI OpenAPIConfig `yaml:"openapi"`
+
+	Stream StreamConfig `yaml:"stream"`
+}
+
+type LoggingConfig struct {
+	// Minimum level of records: debug, info, warn, or error.
+	Level string `yaml:"level"`
+
+	// Output format: text or json.
+	Format string `yaml:"format"`
+
+	// Levels of individual components (e.g., server, streamer, station,
+	// services) which differ from `level`.
+	Components map[string]string `yaml:"components"`
+}
+
+type TLSConfig struct {
+	// PEM files with the certificate and key of the API server. It serves
+	// HTTPS if both are set.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// Interval in which the files are checked for changes and reloaded.
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+
+	// PEM file with the CAs of client certificates. If set, stations can
+	// authenticate with certificates (mutual TLS).
+	ClientCAFile string `yaml:"clientCAFile"`
+
+	// Whether clients without a valid certificate are rejected.
+	RequireClientCert bool `yaml:"requireClientCert"`
+}
+
+// Enabled reports whether the API server uses HTTPS.
+func (tc *TLSConfig) Enabled() bool {
+	return len(tc.CertFile) > 0 && len(tc.KeyFile) > 0
+}
+
+type OpenAPIConfig struct {
+	// Whether requests are rejected if they do not match the API document
+	// served at /openapi.json.
+	ValidateRequests bool `yaml:"validateRequests"`
+}
+
+type StreamConfig struct {
+	// Number of recent events per city which are replayed to reconnecting
+	// clients of event streams.
+	ReplayBuffer int `yaml:"replayBuffer"`
+
+	// Interval of comments sent to keep idle event streams open.
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval"`
+
+	// How long clients wait before reconnecting to event streams.
+	RetryInterval time.Duration `yaml:"retryInterval"`
+
+	// What happens to events for subscribers whose buffer is full:
+	// drop-newest, drop-oldest, or disconnect. Clients may choose a
+	// different policy per subscription.
+	Overflow string `yaml:"overflow"`
+
+	// Dropped events after which subscribers with the disconnect policy are
+	// disconnected.
+	MaxDrops int `yaml:"maxDrops"`
+
+	// Number of submitted measurements which may wait for the streamer.
+	PostQueue int `yaml:"postQueue"`
+
+	// How long submissions wait for room in the queue before they are
+	// rejected with 503.
+	PostTimeout time.Duration `yaml:"postTimeout"`
+
+	// Number of goroutines which distribute events to subscribers. Cities are
+	// assigned to them by hash. One per CPU if 0.
+	Shards int `yaml:"shards"`
+}
+
+type StorageConfig struct {
+	// Directory for the write-ahead log and snapshots. Measurements are only
+	// kept in memory if empty.
+	Dir string `yaml:"dir"`
+
+	// Interval in which the log is compacted into a snapshot.
+	CompactInterval time.Duration `yaml:"compactInterval"`
+}
+
+type ValidationConfig struct {
+	// Ranges of plausible values in the units in which they are stored.
+	Temperature   Range `yaml:"temperature"`   // °C
+	Humidity      Range `yaml:"humidity"`      // %
+	Pressure      Range `yaml:"pressure"`      // hPa
+	WindSpeed     Range `yaml:"windSpeed"`     // m/s
+	WindDirection Range `yaml:"windDirection"` // degrees
+	Precipitation Range `yaml:"precipitation"` // mm
+
+	// How far the time of a measurement may lie in the future.
+	MaxClockSkew time.Duration `yaml:"maxClockSkew"`
+
+	// Whether measurements of cities which are not listed in `cities` are
+	// rejected or start tracking a new city.
+	UnknownCities string `yaml:"unknownCities"`
+}
+
+type Range struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+}
+
+type AuthConfig struct {
+	// How far the timestamp of a signed request may differ from server time.
+	MaxSkew time.Duration `yaml:"maxSkew"`
+
+	// Stations allowed to submit measurements. They authenticate by signing
+	// their requests.
+	Stations []StationConfig `yaml:"stations"`
+
+	// Bearer tokens of clients.
+	Tokens []TokenConfig `yaml:"tokens"`
+}
+
+// Enabled reports whether any credentials are configured.
+func (ac *AuthConfig) Enabled() bool {
+	return len(ac.Stations) > 0 || len(ac.Tokens) > 0
+}
+
+// Required reports whether routes of the given role need authentication.
+// Admin routes are always protected, so they are closed unless an admin token
+// is configured. Submissions are protected as soon as any credentials are
+// configured. Read routes are only protected if tokens are configured, as only
+// tokens grant their role.
+func (ac *AuthConfig) Required(role string) bool {
+	switch role {
+	case RoleAdmin:
+		return true
+	case RoleStation:
+		return ac.Enabled()
+	default:
+		return len(ac.Tokens) > 0
+	}
+}
+
+type StationConfig struct {
+	ID string `yaml:"id"`
+
+	// Secret to sign requests. Optional if the station uses a certificate.
+	Secret Secret `yaml:"secret"`
+
+	// Common name of the client certificate which identifies the station
+	// with mutual TLS.
+	CommonName string `yaml:"commonName"`
+
+	// Cities the station may submit measurements for.
 	Cities []string `yaml:"cities"`
+
+	// PEM files used by the simulated station: its client certificate and
+	// key, and the CAs to verify the server (system CAs if empty).
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	CAFile   string `yaml:"caFile"`
+}
+
+// Roles of tokens. Read tokens may only read data, station tokens may only
+// submit measurements, and admin tokens may do both and manage the service.
+const (
+	RoleRead    = "read"
+	RoleStation = "station"
+	RoleAdmin   = "admin"
+)
+
+type TokenConfig struct {
+	Name  string `yaml:"name"`
+	Token Secret `yaml:"token"`
+	Role  string `yaml:"role"`
+
+	// Cities a station token may submit measurements for.
+	Cities []string `yaml:"cities"`
+}
+
+// Secret is a string which is masked when the config is printed.
+type Secret string
+
+func (Secret) MarshalYAML() (any, error) { return "******", nil }
+
+// Station returns the station with the given ID.
+func (c *Config) Station(id string) (StationConfig, bool) {
+	for _, station := range c.Auth.Stations {
+		if station.ID == id {
+			return station, true
+		}
+	}
+	return StationConfig{}, false
+}
+
+// StationByCommonName returns the station identified by the given common name
+// of a client certificate.
+func (c *Config) StationByCommonName(cn string) (StationConfig, bool) {
+	for _, station := range c.Auth.Stations {
+		if len(station.CommonName) > 0 && station.CommonName == cn {
+			return station, true
+		}
+	}
+	return StationConfig{}, false
+}
+
+// StationFor returns the first station which may submit measurements for the
+// given city.
+func (c *Config) StationFor(city string) (StationConfig, bool) {
+	for _, station := range c.Auth.Stations {
+		if slices.Contains(station.Cities, city) {
+			return station, true
+		}
+	}
+	return StationConfig{}, false
+}
+
+// RateLimitConfig limits the requests to a route per client. Clients are
+// identified by their credentials or, if not authenticated, by their IP.
+type RateLimitConfig struct {
+	// Route pattern as registered by the server, e.g., "POST /cities/{name}".
+	Route string `yaml:"route"`
+
+	// Sustained requests per second.
+	Rate float64 `yaml:"rate"`
+
+	// Maximum number of requests in a burst.
+	Burst int `yaml:"burst"`
 }
 
 func (c *Config) Load(configPath string) error {
@@ -41,6 +339,105 @@ func (c *Config) Load(configPath string) error {
 		return eris.Wrapf(err, "failed to unmarshal config file '%s'", configPath)
 	}
 
+	err = c.validate()
+	if err != nil {
+		return eris.Wrapf(err, "invalid config file '%s'", configPath)
+	}
+
+	return nil
+}
+
+func (c *Config) validate() error {
+	switch c.Validation.UnknownCities {
+	case UnknownCitiesReject, UnknownCitiesCreate:
+	default:
+		return eris.Errorf("unknown policy '%s' for unknown cities", c.Validation.UnknownCities)
+	}
+
+	ranges := map[string]Range{
+		"temperature":   c.Validation.Temperature,
+		"humidity":      c.Validation.Humidity,
+		"pressure":      c.Validation.Pressure,
+		"windSpeed":     c.Validation.WindSpeed,
+		"windDirection": c.Validation.WindDirection,
+		"precipitation": c.Validation.Precipitation,
+	}
+	for name, rng := range ranges {
+		if rng.Min > rng.Max {
+			return eris.Errorf("minimum %s exceeds maximum", name)
+		}
+	}
+
+	if (len(c.TLS.CertFile) > 0) != (len(c.TLS.KeyFile) > 0) {
+		return eris.New("TLS requires both a certificate and a key file")
+	}
+	if !c.TLS.Enabled() && (len(c.TLS.ClientCAFile) > 0 || c.TLS.RequireClientCert) {
+		return eris.New("client certificates require TLS to be enabled")
+	}
+	if c.TLS.RequireClientCert && len(c.TLS.ClientCAFile) == 0 {
+		return eris.New("required client certificates need a client CA file")
+	}
+	if c.TLS.Enabled() && c.TLS.ReloadInterval <= 0 {
+		return eris.New("TLS reload interval must be positive")
+	}
+
+	if len(c.Storage.Dir) > 0 && c.Storage.CompactInterval <= 0 {
+		return eris.New("storage compact interval must be positive")
+	}
+
+	if c.Stream.ReplayBuffer < 0 {
+		return eris.New("stream replay buffer must not be negative")
+	}
+	if c.Stream.HeartbeatInterval <= 0 || c.Stream.RetryInterval <= 0 {
+		return eris.New("stream heartbeat and retry intervals must be positive")
+	}
+	switch c.Stream.Overflow {
+	case OverflowDropNewest, OverflowDropOldest, OverflowDisconnect:
+	default:
+		return eris.Errorf("unknown stream overflow policy '%s'", c.Stream.Overflow)
+	}
+	if c.Stream.MaxDrops < 1 {
+		return eris.New("stream max drops must be positive")
+	}
+	if c.Stream.PostQueue < 1 || c.Stream.PostTimeout <= 0 {
+		return eris.New("stream post queue and timeout must be positive")
+	}
+	if c.Stream.Shards < 0 {
+		return eris.New("stream shards must not be negative")
+	}
+
+	stationIDs := map[string]bool{}
+	for _, station := range c.Auth.Stations {
+		if len(station.ID) == 0 || (len(station.Secret) == 0 && len(station.CommonName) == 0) {
+			return eris.New("stations require an ID and a secret or common name")
+		}
+		if (len(station.CertFile) > 0) != (len(station.KeyFile) > 0) {
+			return eris.Errorf("station '%s' requires both a certificate and a key file", station.ID)
+		}
+		if stationIDs[station.ID] {
+			return eris.Errorf("duplicate station ID '%s'", station.ID)
+		}
+		stationIDs[station.ID] = true
+	}
+
+	for _, limit := range c.RateLimits {
+		if len(limit.Route) == 0 || limit.Rate <= 0 || limit.Burst < 1 {
+			return eris.Errorf("rate limit of route '%s' requires a positive rate and burst", limit.Route)
+		}
+	}
+
+	for _, token := range c.Auth.Tokens {
+		if len(token.Name) == 0 || len(token.Token) == 0 {
+			return eris.New("tokens require a name and a token")
+		}
+
+		switch token.Role {
+		case RoleRead, RoleStation, RoleAdmin:
+		default:
+			return eris.Errorf("unknown role '%s' of token '%s'", token.Role, token.Name)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/risingwavelabs/eris"
+
+	"weather-service/internal/config"
+)
+
+var (
+	// Handler which writes all records. Replaced by `Configure()`.
+	output atomic.Pointer[slog.Handler]
+
+	mutex   sync.Mutex
+	levels  = map[string]*slog.LevelVar{}
+	current = config.LoggingConfig{Level: "info"}
+)
+
+func init() {
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, nil)
+	output.Store(&handler)
+
+	slog.SetDefault(For("default"))
+}
+
+// Configure sets the output format and the level of each component. Loggers
+// returned by `For()` before follow the new configuration.
+func Configure(cfg config.LoggingConfig) error {
+	return configure(cfg, os.Stdout)
+}
+
+func configure(cfg config.LoggingConfig, writer io.Writer) error {
+	var handler slog.Handler
+
+	opts := &slog.HandlerOptions{
+		// Levels are checked per component.
+		Level: slog.LevelDebug,
+	}
+
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(writer, opts)
+	case "json":
+		handler = slog.NewJSONHandler(writer, opts)
+	default:
+		return eris.Errorf("unknown log format '%s'", cfg.Format)
+	}
+
+	_, err := parseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	for component, level := range cfg.Components {
+		_, err = parseLevel(level)
+		if err != nil {
+			return eris.Wrapf(err, "invalid level of component '%s'", component)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current = cfg
+	for component, levelVar := range levels {
+		levelVar.Set(componentLevel(component))
+	}
+	output.Store(&handler)
+
+	return nil
+}
+
+// For returns the logger of the given component. Its records carry the
+// component's name.
+func For(component string) *slog.Logger {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	levelVar, ok := levels[component]
+	if !ok {
+		levelVar = &slog.LevelVar{}
+		levelVar.Set(componentLevel(component))
+		levels[component] = levelVar
+	}
+
+	handler := &componentHandler{level: levelVar}
+	return slog.New(handler).With("component", component)
+}
+
+// componentLevel must be called with `mutex` locked.
+func componentLevel(component string) slog.Level {
+	levelStr, ok := current.Components[component]
+	if !ok {
+		levelStr = current.Level
+	}
+
+	// Levels are validated by `Configure()`.
+	level, _ := parseLevel(levelStr)
+	return level
+}
+
+func parseLevel(level string) (slog.Level, error) {
+	if len(level) == 0 {
+		return slog.LevelInfo, nil
+	}
+
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return 0, eris.Wrapf(err, "unknown log level '%s'", level)
+	}
+
+	return lvl, nil
+}
+
+// componentHandler filters records by the level of its component and passes
+// them to the current output handler. Attributes and groups are applied when
+// a record is handled as the output might have been replaced in between.
+type componentHandler struct {
+	level *slog.LevelVar
+	ops   []func(slog.Handler) slog.Handler
+}
+
+func (ch *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= ch.level.Level()
+}
+
+func (ch *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := *output.Load()
+	for _, op := range ch.ops {
+		handler = op(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (ch *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return ch.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (ch *componentHandler) WithGroup(name string) slog.Handler {
+	return ch.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (ch *componentHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(ch.ops), len(ch.ops)+1)
+	copy(ops, ch.ops)
+
+	return &componentHandler{
+		level: ch.level,
+		ops:   append(ops, op),
+	}
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/config"
+)
+
+// Ensures that loggers created before configuration follow the configured
+// format and the levels of their components.
+func TestConfigure(t *testing.T) {
+	serverLogger := For("test-server")
+	stationLogger := For("test-station").With("city", "Berlin")
+
+	var buf bytes.Buffer
+	err := configure(config.LoggingConfig{
+		Level:      "debug",
+		Format:     "json",
+		Components: map[string]string{"test-station": "warn"},
+	}, &buf)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = configure(config.LoggingConfig{}, &bytes.Buffer{}) })
+
+	serverLogger.Debug("visible", "key", 1)
+	stationLogger.Info("hidden")
+	stationLogger.Warn("visible too")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(lines[0], &record))
+	require.Equal(t, "visible", record["msg"])
+	require.Equal(t, "test-server", record["component"])
+
+	require.NoError(t, json.Unmarshal(lines[1], &record))
+	require.Equal(t, "test-station", record["component"])
+	require.Equal(t, "Berlin", record["city"])
+
+	require.Error(t, configure(config.LoggingConfig{Format: "xml"}, &buf))
+	require.Error(t, configure(config.LoggingConfig{Level: "loud"}, &buf))
+}
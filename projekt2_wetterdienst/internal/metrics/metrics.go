@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default upper bounds of histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+// Default is the registry used by the constructors of this package.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a metric with all its label combinations.
+type family struct {
+	name, help, kind string
+	labelNames       []string
+	buckets          []float64
+
+	mutex    sync.RWMutex
+	children map[string]*child
+}
+
+// child is a metric with a fixed set of label values.
+type child struct {
+	labelValues []string
+
+	value atomicFloat
+
+	// Only used by histograms.
+	counts []atomic.Uint64
+	sum    atomicFloat
+}
+
+func (reg *Registry) register(fam *family) *family {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if _, ok := reg.families[fam.name]; ok {
+		panic(fmt.Sprintf("metric '%s' is already registered", fam.name))
+	}
+
+	fam.children = map[string]*child{}
+	reg.families[fam.name] = fam
+	return fam
+}
+
+func (fam *family) child(labelValues []string) *child {
+	if len(labelValues) != len(fam.labelNames) {
+		panic(fmt.Sprintf("metric '%s' expects %d label values, got %d",
+			fam.name, len(fam.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	fam.mutex.RLock()
+	ch, ok := fam.children[key]
+	fam.mutex.RUnlock()
+	if ok {
+		return ch
+	}
+
+	fam.mutex.Lock()
+	defer fam.mutex.Unlock()
+
+	ch, ok = fam.children[key]
+	if !ok {
+		ch = &child{
+			labelValues: slices.Clone(labelValues),
+			counts:      make([]atomic.Uint64, len(fam.buckets)),
+		}
+		fam.children[key] = ch
+	}
+
+	return ch
+}
+
+func (fam *family) delete(labelValues []string) {
+	fam.mutex.Lock()
+	defer fam.mutex.Unlock()
+
+	delete(fam.children, strings.Join(labelValues, "\xff"))
+}
+
+//
+// Metric types.
+
+// Counter is a value which only increases.
+type Counter struct{ fam *family }
+
+func (reg *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{reg.register(&family{name: name, help: help, kind: "counter", labelNames: labelNames})}
+}
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counters cannot decrease")
+	}
+	c.fam.child(labelValues).value.add(delta)
+}
+
+// Gauge is a value which can increase and decrease.
+type Gauge struct{ fam *family }
+
+func (reg *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{reg.register(&family{name: name, help: help, kind: "gauge", labelNames: labelNames})}
+}
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return Default.NewGauge(name, help, labelNames...)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.fam.child(labelValues).value.store(value)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.fam.child(labelValues).value.add(delta)
+}
+
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+// Delete removes the given label combination, e.g., to limit the number of
+// reported series for dynamic labels.
+func (g *Gauge) Delete(labelValues ...string) { g.fam.delete(labelValues) }
+
+// Histogram counts observations in buckets.
+type Histogram struct{ fam *family }
+
+func (reg *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return &Histogram{reg.register(&family{
+		name: name, help: help, kind: "histogram", labelNames: labelNames, buckets: buckets,
+	})}
+}
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	ch := h.fam.child(labelValues)
+
+	idx, _ := slices.BinarySearch(h.fam.buckets, value)
+	if idx < len(ch.counts) {
+		ch.counts[idx].Add(1)
+	}
+
+	ch.value.add(1) // Count.
+	ch.sum.add(value)
+}
+
+//
+// Exposition.
+
+// Handler serves all metrics of the registry in the Prometheus text format.
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		writer := bufio.NewWriter(w)
+		reg.write(writer)
+		_ = writer.Flush()
+	})
+}
+
+func (reg *Registry) write(w *bufio.Writer) {
+	reg.mutex.Lock()
+	families := make([]*family, 0, len(reg.families))
+	for _, fam := range reg.families {
+		families = append(families, fam)
+	}
+	reg.mutex.Unlock()
+
+	slices.SortFunc(families, func(a, b *family) int { return cmp.Compare(a.name, b.name) })
+
+	for _, fam := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n", fam.name, escapeHelp(fam.help))
+		fmt.Fprintf(w, "# TYPE %s %s\n", fam.name, fam.kind)
+
+		fam.mutex.RLock()
+		children := make([]*child, 0, len(fam.children))
+		for _, ch := range fam.children {
+			children = append(children, ch)
+		}
+		fam.mutex.RUnlock()
+
+		slices.SortFunc(children, func(a, b *child) int { return slices.Compare(a.labelValues, b.labelValues) })
+
+		for _, ch := range children {
+			labels := formatLabels(fam.labelNames, ch.labelValues)
+
+			if fam.kind != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", fam.name, labels, formatFloat(ch.value.load()))
+				continue
+			}
+
+			// Copies as the slices are shared with concurrent scrapes.
+			bucketNames := slices.Concat(fam.labelNames, []string{"le"})
+			bucketValues := slices.Concat(ch.labelValues, []string{""})
+			bucketLabels := func(upper string) string {
+				bucketValues[len(bucketValues)-1] = upper
+				return formatLabels(bucketNames, bucketValues)
+			}
+
+			cumulative := uint64(0)
+			for i, upper := range fam.buckets {
+				cumulative += ch.counts[i].Load()
+				fmt.Fprintf(w, "%s_bucket%s %d\n", fam.name, bucketLabels(formatFloat(upper)), cumulative)
+			}
+
+			count := ch.value.load()
+			fmt.Fprintf(w, "%s_bucket%s %s\n", fam.name, bucketLabels("+Inf"), formatFloat(count))
+			fmt.Fprintf(w, "%s_sum%s %s\n", fam.name, labels, formatFloat(ch.sum.load()))
+			fmt.Fprintf(w, "%s_count%s %s\n", fam.name, labels, formatFloat(count))
+		}
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string   { return helpEscaper.Replace(help) }
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+
+// atomicFloat is a float64 which can be updated concurrently.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (af *atomicFloat) load() float64       { return math.Float64frombits(af.bits.Load()) }
+func (af *atomicFloat) store(value float64) { af.bits.Store(math.Float64bits(value)) }
+
+func (af *atomicFloat) add(delta float64) {
+	for {
+		old := af.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if af.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
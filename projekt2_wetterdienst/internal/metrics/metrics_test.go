@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that all metric types are written in the text exposition format
+// with sorted families and series, cumulative buckets, and escaped labels.
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+
+	requests := reg.NewCounter("requests_total", "Number of requests.", "code")
+	requests.Inc("200")
+	requests.Add(2, "200")
+	requests.Inc("404")
+
+	listeners := reg.NewGauge("listeners", "Current\nlisteners.", "city")
+	listeners.Inc(`Quote"d`)
+	listeners.Set(5, "Berlin")
+	listeners.Dec("Berlin")
+	listeners.Inc("Hamburg")
+	listeners.Delete("Hamburg")
+
+	duration := reg.NewHistogram("duration_seconds", "Duration.", []float64{1, 0.1})
+	duration.Observe(0.05)
+	duration.Observe(0.1)
+	duration.Observe(0.5)
+	duration.Observe(3)
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "version=0.0.4")
+	require.Equal(t, `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 2
+duration_seconds_bucket{le="1"} 3
+duration_seconds_bucket{le="+Inf"} 4
+duration_seconds_sum 3.65
+duration_seconds_count 4
+# HELP listeners Current\nlisteners.
+# TYPE listeners gauge
+listeners{city="Berlin"} 4
+listeners{city="Quote\"d"} 1
+# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="404"} 1
+`, rec.Body.String())
+}
+
+// Ensures that a metric name cannot be registered twice and that the number
+// of label values is checked.
+func TestMisuse(t *testing.T) {
+	t.Parallel()
+
+	reg := NewRegistry()
+	counter := reg.NewCounter("total", "Total.", "a", "b")
+
+	require.Panics(t, func() { reg.NewGauge("total", "Again.") })
+	require.Panics(t, func() { counter.Inc("only one") })
+	require.Panics(t, func() { counter.Add(-1, "a", "b") })
+}
@@ -0,0 +1,100 @@
+package openapi
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testItem struct {
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	Time     time.Time         `json:"time"`
+	Parent   *testItem         `json:"parent"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Ignored  string            `json:"-"`
+	internal string
+}
+
+// Ensures that schemas follow the JSON encoding of types, including omitted
+// fields, pointers, and recursive types.
+func TestSchemaFor(t *testing.T) {
+	t.Parallel()
+
+	gen := NewGenerator()
+	schema := SchemaFor[[]testItem](gen)
+
+	require.Equal(t, "array", schema.Type)
+	require.Equal(t, "#/components/schemas/testItem", schema.Items.Ref)
+
+	item := gen.Schemas["testItem"]
+	require.Equal(t, []string{"name", "time", "parent"}, item.Required)
+	require.ElementsMatch(t, []string{"name", "count", "time", "parent", "tags", "labels"}, slices.Collect(maps.Keys(item.Properties)))
+	require.Equal(t, "date-time", item.Properties["time"].Format)
+	require.True(t, item.Properties["parent"].Nullable)
+	require.Equal(t, "#/components/schemas/testItem", item.Properties["parent"].OneOf[0].Ref)
+	require.Equal(t, "string", item.Properties["labels"].AdditionalProperties.(*Schema).Type)
+}
+
+// Ensures that requests are checked for parameters, media types, and bodies
+// and that all problems are reported.
+func TestValidateRequest(t *testing.T) {
+	t.Parallel()
+
+	gen := NewGenerator()
+	item := SchemaFor[testItem](gen).Ref
+	doc := &Document{Components: Components{Schemas: gen.Schemas}}
+	doc.Components.Schemas["closed"] = gen.Schemas["testItem"].Closed(nil)
+
+	op := &Operation{
+		Parameters: []Parameter{
+			{Name: "units", In: InQuery, Schema: String("", "metric", "imperial")},
+			{Name: "limit", In: InQuery, Required: true, Schema: &Schema{Type: "integer"}},
+		},
+		RequestBody: &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"application/json":     {Schema: Ref("closed")},
+				"application/x-ndjson": {Schema: &Schema{Ref: item}},
+			},
+		},
+	}
+
+	validate := func(query, contentType, body string) []ValidationError {
+		r := httptest.NewRequest(http.MethodPost, "/?"+query, strings.NewReader(body))
+		if len(contentType) > 0 {
+			r.Header.Set("Content-Type", contentType)
+		}
+		return doc.ValidateRequest(op, r, []byte(body))
+	}
+
+	valid := `{"name": "a", "time": "2025-10-01T12:00:00Z", "parent": null}`
+	require.Empty(t, validate("limit=5&units=metric", "", valid))
+
+	require.Equal(t, []ValidationError{
+		{"query.units", "must be one of [metric imperial]"},
+		{"query.limit", "must be a number"},
+	}, validate("limit=x&units=si", "application/json", valid))
+
+	require.Equal(t, []ValidationError{{"query.limit", "is required"}, {"body", "is required"}}, validate("", "", ""))
+
+	require.Equal(t, []ValidationError{
+		{"body.time", "is required"},
+		{"body.count", "must be an integer"},
+		{"body.extra", "is not allowed"},
+		{"body.parent.name", "must be a string"},
+	}, validate("limit=1", "", `{"name": "a", "parent": {"name": 1, "time": "2025-10-01T12:00:00Z", "parent": null}, "count": 1.5, "extra": true}`))
+
+	require.Equal(t, []ValidationError{{"body[2].time", "must be an RFC 3339 timestamp"}},
+		validate("limit=1", "application/x-ndjson", valid+"\n"+`{"name": "b", "time": "yesterday", "parent": null, "extra": 1}`))
+
+	require.Equal(t, []ValidationError{{"header.Content-Type", "unsupported media type 'text/plain'"}},
+		validate("limit=1", "text/plain", valid))
+}
@@ -0,0 +1,146 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Generator derives schemas from Go types by reflection. Named structs become
+// components which are referenced by the returned schemas.
+type Generator struct {
+	Schemas map[string]*Schema
+
+	overrides map[reflect.Type]*Schema
+}
+
+func NewGenerator() *Generator {
+	return &Generator{
+		Schemas: map[string]*Schema{},
+		overrides: map[reflect.Type]*Schema{
+			reflect.TypeFor[time.Time]():     String("date-time"),
+			reflect.TypeFor[time.Duration](): {Type: "integer", Format: "int64", Description: "Nanoseconds"},
+		},
+	}
+}
+
+// Override uses the given schema for all values of type `T`, e.g., to list
+// the allowed values of a string type.
+func Override[T any](gen *Generator, schema *Schema) {
+	gen.overrides[reflect.TypeFor[T]()] = schema
+}
+
+// SchemaFor returns the schema of values of type `T`.
+func SchemaFor[T any](gen *Generator) *Schema {
+	return gen.Schema(reflect.TypeFor[T]())
+}
+
+// Schema returns the schema of the given type as encoded by `encoding/json`.
+func (gen *Generator) Schema(typ reflect.Type) *Schema {
+	if schema, ok := gen.overrides[typ]; ok {
+		return schema
+	}
+
+	switch typ.Kind() {
+	case reflect.Pointer:
+		schema := *gen.Schema(typ.Elem())
+		if len(schema.Ref) > 0 {
+			// Siblings of references are ignored, so the reference is
+			// wrapped.
+			return &Schema{OneOf: []*Schema{&schema}, Nullable: true}
+		}
+		schema.Nullable = true
+		return &schema
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer", Format: intFormat(typ)}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: intFormat(typ), Minimum: new(float64)}
+
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: gen.Schema(typ.Elem())}
+
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: gen.Schema(typ.Elem())}
+
+	case reflect.Struct:
+		if len(typ.Name()) == 0 {
+			return gen.structSchema(typ)
+		}
+
+		if _, ok := gen.Schemas[typ.Name()]; !ok {
+			// Registered before the fields to support recursive types.
+			gen.Schemas[typ.Name()] = &Schema{}
+			*gen.Schemas[typ.Name()] = *gen.structSchema(typ)
+		}
+		return Ref(typ.Name())
+
+	default:
+		// Interfaces and other kinds allow any value.
+		return &Schema{}
+	}
+}
+
+func (gen *Generator) structSchema(typ reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	gen.addFields(schema, typ)
+	return schema
+}
+
+func (gen *Generator) addFields(schema *Schema, typ reflect.Type) {
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Fields of embedded structs without name are promoted.
+		if field.Anonymous && len(name) == 0 {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				gen.addFields(schema, embedded)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		schema.Properties[name] = gen.Schema(field.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+func intFormat(typ reflect.Type) string {
+	if typ.Bits() <= 32 {
+		return "int32"
+	}
+	return "int64"
+}
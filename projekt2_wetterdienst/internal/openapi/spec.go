@@ -0,0 +1,135 @@
+package openapi
+
+import "slices"
+
+// Version of the OpenAPI specification documents are written in.
+const Version = "3.0.3"
+
+// Document is the root of an OpenAPI document. Only the parts used by this
+// service are modelled.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations of a path by lower-case HTTP method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Locations of parameters.
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema describes JSON values. Either `Ref` or the other fields are set.
+type Schema struct {
+	Ref string `json:"$ref,omitempty"`
+
+	Type        string   `json:"type,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Nullable    bool     `json:"nullable,omitempty"`
+	Enum        []any    `json:"enum,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+
+	// Either a `*Schema` of the values of other properties or `false` if
+	// other properties are not allowed.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+
+	Items *Schema   `json:"items,omitempty"`
+	OneOf []*Schema `json:"oneOf,omitempty"`
+}
+
+// Ref returns a schema referencing the component with the given name.
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Closed returns a copy of the object schema which does not allow other
+// properties, optionally extended by the given ones.
+func (s *Schema) Closed(extra map[string]*Schema, required ...string) *Schema {
+	closed := *s
+	closed.AdditionalProperties = false
+
+	closed.Properties = make(map[string]*Schema, len(s.Properties)+len(extra))
+	for name, prop := range s.Properties {
+		closed.Properties[name] = prop
+	}
+	for name, prop := range extra {
+		closed.Properties[name] = prop
+	}
+
+	closed.Required = append(slices.Clone(s.Required), required...)
+	return &closed
+}
+
+// String returns a schema of strings with the given format and allowed values.
+func String(format string, enum ...any) *Schema {
+	return &Schema{Type: "string", Format: format, Enum: enum}
+}
@@ -0,0 +1,326 @@
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError describes why a part of a request does not match the
+// document. Fields are prefixed by their location, e.g., "query.units" or
+// "body.temperature.value".
+type ValidationError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidateRequest checks the parameters and body of a request against the
+// operation. `body` must contain the complete request body.
+func (doc *Document) ValidateRequest(op *Operation, r *http.Request, body []byte) []ValidationError {
+	var errs []ValidationError
+
+	for _, param := range op.Parameters {
+		var (
+			value   string
+			present bool
+		)
+
+		switch param.In {
+		case InPath:
+			value = r.PathValue(param.Name)
+			present = len(value) > 0
+		case InQuery:
+			present = r.URL.Query().Has(param.Name)
+			value = r.URL.Query().Get(param.Name)
+		case InHeader:
+			value = r.Header.Get(param.Name)
+			present = len(value) > 0
+		}
+
+		field := param.In + "." + param.Name
+		if !present {
+			if param.Required {
+				errs = append(errs, ValidationError{field, "is required"})
+			}
+			continue
+		}
+
+		errs = append(errs, doc.validateParameter(param.Schema, value, field)...)
+	}
+
+	if op.RequestBody != nil {
+		errs = append(errs, doc.validateBody(op.RequestBody, r.Header.Get("Content-Type"), body)...)
+	}
+
+	return errs
+}
+
+func (doc *Document) validateBody(reqBody *RequestBody, contentType string, body []byte) []ValidationError {
+	if len(bytes.TrimSpace(body)) == 0 {
+		if reqBody.Required {
+			return []ValidationError{{"body", "is required"}}
+		}
+		return nil
+	}
+
+	mediaType := "application/json"
+	if len(contentType) > 0 {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return []ValidationError{{"header.Content-Type", err.Error()}}
+		}
+	}
+
+	content, ok := reqBody.Content[mediaType]
+	if !ok {
+		return []ValidationError{{"header.Content-Type", fmt.Sprintf("unsupported media type '%s'", mediaType)}}
+	}
+
+	// Each line of newline-delimited JSON must match the schema.
+	if mediaType == "application/x-ndjson" {
+		var errs []ValidationError
+
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(nil, len(body)+1)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			errs = append(errs, doc.ValidateJSON(content.Schema, scanner.Bytes(), fmt.Sprintf("body[%d]", line))...)
+		}
+
+		return errs
+	}
+
+	return doc.ValidateJSON(content.Schema, body, "body")
+}
+
+// ValidateJSON decodes a single JSON value and checks it against the schema.
+func (doc *Document) ValidateJSON(schema *Schema, data []byte, field string) []ValidationError {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	err := decoder.Decode(&value)
+	if err == nil && decoder.More() {
+		err = fmt.Errorf("unexpected data after value")
+	}
+	if err != nil {
+		return []ValidationError{{field, "invalid JSON: " + err.Error()}}
+	}
+
+	return doc.ValidateValue(schema, value, field)
+}
+
+// validateParameter converts the string value of a parameter according to
+// the type of its schema before validating it.
+func (doc *Document) validateParameter(schema *Schema, raw, field string) []ValidationError {
+	schema = doc.resolve(schema)
+
+	var value any = raw
+	switch schema.Type {
+	case "integer", "number":
+		_, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return []ValidationError{{field, "must be a number"}}
+		}
+		value = json.Number(raw)
+
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return []ValidationError{{field, "must be a boolean"}}
+		}
+		value = b
+	}
+
+	return doc.ValidateValue(schema, value, field)
+}
+
+// ValidateValue checks a value as decoded by `encoding/json` with
+// `UseNumber()` against the schema.
+func (doc *Document) ValidateValue(schema *Schema, value any, field string) []ValidationError {
+	schema = doc.resolve(schema)
+
+	if value == nil {
+		if schema.Nullable || len(schema.Type) == 0 && len(schema.OneOf) == 0 {
+			return nil
+		}
+		return []ValidationError{{field, "must not be null"}}
+	}
+
+	if len(schema.OneOf) > 0 {
+		return doc.validateOneOf(schema, value, field)
+	}
+
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(allowed any) bool {
+		return fmt.Sprint(allowed) == fmt.Sprint(value)
+	}) {
+		return []ValidationError{{field, fmt.Sprintf("must be one of %v", schema.Enum)}}
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return typeError(field, "a boolean")
+		}
+
+	case "integer", "number":
+		return validateNumber(schema, value, field)
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return typeError(field, "a string")
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return []ValidationError{{field, "must be an RFC 3339 timestamp"}}
+			}
+		}
+
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return typeError(field, "an array")
+		}
+
+		var errs []ValidationError
+		for i, item := range items {
+			errs = append(errs, doc.ValidateValue(schema.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return errs
+
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return typeError(field, "an object")
+		}
+		return doc.validateObject(schema, obj, field)
+	}
+
+	return nil
+}
+
+func (doc *Document) validateOneOf(schema *Schema, value any, field string) []ValidationError {
+	var (
+		matches int
+		best    []ValidationError
+	)
+
+	for _, option := range schema.OneOf {
+		errs := doc.ValidateValue(option, value, field)
+		if len(errs) == 0 {
+			matches++
+		} else if best == nil || len(errs) < len(best) {
+			// The errors of the closest option are the most helpful.
+			best = errs
+		}
+	}
+
+	switch matches {
+	case 0:
+		return best
+	case 1:
+		return nil
+	default:
+		return []ValidationError{{field, "matches more than one schema"}}
+	}
+}
+
+func (doc *Document) validateObject(schema *Schema, obj map[string]any, field string) []ValidationError {
+	var errs []ValidationError
+
+	for _, name := range schema.Required {
+		if _, ok := obj[name]; !ok {
+			errs = append(errs, ValidationError{join(field, name), "is required"})
+		}
+	}
+
+	// Sorted for deterministic errors.
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if prop, ok := schema.Properties[name]; ok {
+			errs = append(errs, doc.ValidateValue(prop, obj[name], join(field, name))...)
+			continue
+		}
+
+		switch additional := schema.AdditionalProperties.(type) {
+		case bool:
+			if !additional {
+				errs = append(errs, ValidationError{join(field, name), "is not allowed"})
+			}
+		case *Schema:
+			errs = append(errs, doc.ValidateValue(additional, obj[name], join(field, name))...)
+		}
+	}
+
+	return errs
+}
+
+func validateNumber(schema *Schema, value any, field string) []ValidationError {
+	num, ok := value.(json.Number)
+	if !ok {
+		return typeError(field, "a number")
+	}
+
+	number, err := num.Float64()
+	if err != nil {
+		return typeError(field, "a number")
+	}
+
+	if schema.Type == "integer" {
+		if _, err := num.Int64(); err != nil {
+			return typeError(field, "an integer")
+		}
+	}
+
+	if schema.Minimum != nil && number < *schema.Minimum {
+		return []ValidationError{{field, fmt.Sprintf("must be at least %v", *schema.Minimum)}}
+	}
+	if schema.Maximum != nil && number > *schema.Maximum {
+		return []ValidationError{{field, fmt.Sprintf("must be at most %v", *schema.Maximum)}}
+	}
+
+	return nil
+}
+
+// resolve follows references to components.
+func (doc *Document) resolve(schema *Schema) *Schema {
+	for schema != nil && len(schema.Ref) > 0 {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		schema = doc.Components.Schemas[name]
+	}
+
+	if schema == nil {
+		return &Schema{}
+	}
+	return schema
+}
+
+func typeError(field, kind string) []ValidationError {
+	return []ValidationError{{field, "must be " + kind}}
+}
+
+func join(field, name string) string {
+	if len(field) == 0 {
+		return name
+	}
+	return field + "." + name
+}
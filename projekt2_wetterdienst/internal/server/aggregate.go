@@ -0,0 +1,59 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// WindowStats summarises the measurements of a single time window. It mirrors
+// `CityStats` of the row challenge.
+type WindowStats struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Unit  Unit      `json:"unit"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Mean  float64   `json:"mean"`
+	Count int       `json:"count"`
+
+	sum float64
+}
+
+func (ws *WindowStats) add(value float64) {
+	ws.sum += value
+	ws.Count++
+	ws.Min = min(ws.Min, value)
+	ws.Max = max(ws.Max, value)
+	ws.Mean = ws.sum / float64(ws.Count)
+}
+
+// aggregate splits the given measurements, which must be ordered by time,
+// into windows of the given size and computes the statistics of the given
+// reading for each. Windows are aligned to multiples of `window` since the
+// zero time; empty windows and measurements without the reading are omitted.
+func aggregate(msgs []Measurement, rd reading, window time.Duration) []WindowStats {
+	allStats := []WindowStats{}
+
+	for _, msg := range msgs {
+		qty := *rd.field(&msg)
+		if qty == nil {
+			continue
+		}
+
+		start := msg.Time.Truncate(window)
+
+		if len(allStats) == 0 || !allStats[len(allStats)-1].Start.Equal(start) {
+			allStats = append(allStats, WindowStats{
+				Start: start,
+				End:   start.Add(window),
+				Unit:  qty.Unit,
+				Min:   math.Inf(1),
+				Max:   math.Inf(-1),
+			})
+		}
+
+		allStats[len(allStats)-1].add(qty.Value)
+	}
+
+	return allStats
+}
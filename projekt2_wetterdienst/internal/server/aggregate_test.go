@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that measurements are grouped into aligned windows and that empty
+// windows are omitted.
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+	msgs := []Measurement{
+		TempMessage{Temp: 10, Time: base}.Measurement(),
+		TempMessage{Temp: 20, Time: base.Add(10 * time.Minute)}.Measurement(),
+		{Time: base.Add(20 * time.Minute)}, // Without temperature.
+		TempMessage{Temp: 15, Time: base.Add(59 * time.Minute)}.Measurement(),
+		TempMessage{Temp: -5, Time: base.Add(3*time.Hour + time.Minute)}.Measurement(),
+	}
+
+	rd, ok := findReading("temperature")
+	require.True(t, ok)
+
+	allStats := aggregate(msgs, rd, time.Hour)
+	require.Len(t, allStats, 2)
+
+	require.Equal(t, base, allStats[0].Start)
+	require.Equal(t, base.Add(time.Hour), allStats[0].End)
+	require.InDelta(t, 10.0, allStats[0].Min, 1e-9)
+	require.InDelta(t, 20.0, allStats[0].Max, 1e-9)
+	require.InDelta(t, 15.0, allStats[0].Mean, 1e-9)
+	require.Equal(t, 3, allStats[0].Count)
+
+	require.Equal(t, base.Add(3*time.Hour), allStats[1].Start)
+	require.InDelta(t, -5.0, allStats[1].Min, 1e-9)
+	require.InDelta(t, -5.0, allStats[1].Max, 1e-9)
+	require.Equal(t, 1, allStats[1].Count)
+
+	require.Empty(t, aggregate(nil, rd, time.Hour))
+}
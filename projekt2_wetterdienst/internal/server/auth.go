@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"weather-service/internal/auth"
+	"weather-service/internal/config"
+)
+
+type contextKey int
+
+// Keys of values attached to the context of requests.
+const (
+	principalKey contextKey = iota
+	requestIDKey
+)
+
+// principal is the authenticated client of a request.
+type principal struct {
+	name string
+	role string
+
+	// Cities a station may submit measurements for.
+	cities []string
+}
+
+// Public routes do not require any authentication.
+const rolePublic = ""
+
+// authorize only passes requests to `next` whose client has the given role.
+// Admins have every role. Requests without valid credentials are answered
+// with 401, requests with a role which is not sufficient with 403. The
+// principal is attached to the context of the request.
+func authorize(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if role == rolePublic || !config.C.Auth.Required(role) {
+			next(w, r)
+			return
+		}
+
+		prin, ok := authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if prin.role != role && prin.role != config.RoleAdmin {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "role '" + prin.role + "' may not access this route"})
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey, prin)))
+	}
+}
+
+// authenticate identifies the client either by the signature of a station, by
+// a bearer token, or by the client certificate of a station. An error response
+// is sent if that fails.
+func authenticate(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	if stationID := r.Header.Get(auth.HeaderStation); len(stationID) > 0 {
+		return authenticateStation(w, r, stationID)
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) == 0 && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return authenticateCertificate(w, r)
+	}
+
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		unauthorized(w, "missing credentials")
+		return principal{}, false
+	}
+
+	// Compare with all tokens to not reveal which one matched by timing.
+	var (
+		match config.TokenConfig
+		found bool
+	)
+	for _, tokenCfg := range config.C.Auth.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(tokenCfg.Token)) == 1 {
+			match, found = tokenCfg, true
+		}
+	}
+
+	if !found {
+		unauthorized(w, "invalid token")
+		return principal{}, false
+	}
+
+	return principal{match.Name, match.Role, match.Cities}, true
+}
+
+// authenticateStation verifies the signature of a station's request. The body
+// is restored so handlers can read it again.
+func authenticateStation(w http.ResponseWriter, r *http.Request, stationID string) (principal, bool) {
+	station, ok := config.C.Station(stationID)
+	if !ok {
+		unauthorized(w, "unknown station")
+		return principal{}, false
+	} else if len(station.Secret) == 0 {
+		unauthorized(w, "station does not sign requests")
+		return principal{}, false
+	}
+
+	body, ok := readBody(w, r, maxBatchSize)
+	if !ok {
+		return principal{}, false
+	}
+
+	err := auth.Verify(r, body, []byte(station.Secret), time.Now(), config.C.Auth.MaxSkew)
+	if err != nil {
+		unauthorized(w, "invalid signature: "+err.Error())
+		return principal{}, false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return principal{station.ID, config.RoleStation, station.Cities}, true
+}
+
+// authenticateCertificate maps the common name of a verified client
+// certificate to a station.
+func authenticateCertificate(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+	station, ok := config.C.StationByCommonName(cn)
+	if !ok {
+		unauthorized(w, "unknown client certificate")
+		return principal{}, false
+	}
+
+	return principal{station.ID, config.RoleStation, station.Cities}, true
+}
+
+// mayWrite reports whether the request may submit measurements of the given
+// city.
+func mayWrite(r *http.Request, city string) bool {
+	if !config.C.Auth.Enabled() {
+		return true
+	}
+
+	prin, ok := r.Context().Value(principalKey).(principal)
+	if !ok {
+		return false
+	}
+
+	return prin.role == config.RoleAdmin || slices.Contains(prin.cities, city)
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer, HMAC-SHA256`)
+	writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: msg})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/auth"
+	"weather-service/internal/config"
+)
+
+// Ensures that every role answers missing or invalid credentials with 401 and
+// insufficient roles with 403.
+func TestAuthorize(t *testing.T) {
+	authCfg := config.C.Auth
+	config.C.Auth = config.AuthConfig{
+		MaxSkew:  time.Minute,
+		Stations: []config.StationConfig{{ID: "station-1", Secret: "station-secret", Cities: []string{"Berlin"}}},
+		Tokens: []config.TokenConfig{
+			{Name: "reader", Token: "read-token", Role: config.RoleRead},
+			{Name: "admin", Token: "admin-token", Role: config.RoleAdmin},
+		},
+	}
+	t.Cleanup(func() { config.C.Auth = authCfg })
+
+	credentials := map[string]func(r *http.Request, body []byte){
+		"missing":       func(r *http.Request, body []byte) {},
+		"bad token":     func(r *http.Request, body []byte) { r.Header.Set("Authorization", "Bearer wrong") },
+		"bad signature": func(r *http.Request, body []byte) { auth.Sign(r, body, "station-1", []byte("wrong"), time.Now()) },
+		"read":          func(r *http.Request, body []byte) { r.Header.Set("Authorization", "Bearer read-token") },
+		"station": func(r *http.Request, body []byte) {
+			auth.Sign(r, body, "station-1", []byte("station-secret"), time.Now())
+		},
+		"admin": func(r *http.Request, body []byte) { r.Header.Set("Authorization", "Bearer admin-token") },
+	}
+
+	testCases := []struct {
+		role  string
+		codes map[string]int
+	}{
+		{rolePublic, map[string]int{"missing": 200, "bad token": 200, "bad signature": 200, "read": 200, "station": 200, "admin": 200}},
+		{config.RoleRead, map[string]int{"missing": 401, "bad token": 401, "bad signature": 401, "read": 200, "station": 403, "admin": 200}},
+		{config.RoleStation, map[string]int{"missing": 401, "bad token": 401, "bad signature": 401, "read": 403, "station": 200, "admin": 200}},
+		{config.RoleAdmin, map[string]int{"missing": 401, "bad token": 401, "bad signature": 401, "read": 403, "station": 403, "admin": 200}},
+	}
+
+	for _, tc := range testCases {
+		handler := authorize(tc.role, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		for name, setCredentials := range credentials {
+			body := []byte(`{"temp":21}`)
+			r := httptest.NewRequest(http.MethodPost, "/cities/Berlin", strings.NewReader(string(body)))
+			setCredentials(r, body)
+
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+
+			require.Equal(t, tc.codes[name], rec.Code, "role '%s' with %s credentials", tc.role, name)
+			if rec.Code == http.StatusUnauthorized {
+				require.NotEmpty(t, rec.Header().Get("WWW-Authenticate"), "role '%s' with %s credentials", tc.role, name)
+			} else {
+				require.Empty(t, rec.Header().Get("WWW-Authenticate"), "role '%s' with %s credentials", tc.role, name)
+			}
+		}
+	}
+}
+
+// Ensures that reads need no credentials if stations but no tokens are
+// configured, that admin routes stay closed, and that stations may only write
+// their own cities.
+func TestAuthorizeStationsOnly(t *testing.T) {
+	authCfg := config.C.Auth
+	config.C.Auth = config.AuthConfig{
+		MaxSkew:  time.Minute,
+		Stations: []config.StationConfig{{ID: "station-1", Secret: "station-secret", Cities: []string{"Berlin"}}},
+	}
+	t.Cleanup(func() { config.C.Auth = authCfg })
+
+	var mayWriteBerlin, mayWriteHamburg bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mayWriteBerlin, mayWriteHamburg = mayWrite(r, "Berlin"), mayWrite(r, "Hamburg")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	rec := httptest.NewRecorder()
+	authorize(config.RoleRead, handler)(rec, httptest.NewRequest(http.MethodGet, "/cities", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	authorize(config.RoleAdmin, handler)(rec, httptest.NewRequest(http.MethodPost, "/admin/compact", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/compact", nil)
+	auth.Sign(r, nil, "station-1", []byte("station-secret"), time.Now())
+	rec = httptest.NewRecorder()
+	authorize(config.RoleAdmin, handler)(rec, r)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = httptest.NewRecorder()
+	authorize(config.RoleStation, handler)(rec, httptest.NewRequest(http.MethodPost, "/cities/Berlin", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Signed requests whose body cannot be read are bad requests.
+	r = httptest.NewRequest(http.MethodPost, "/cities/Berlin", iotest.ErrReader(errors.New("connection reset")))
+	r.Header.Set(auth.HeaderStation, "station-1")
+	rec = httptest.NewRecorder()
+	authorize(config.RoleStation, handler)(rec, r)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/cities/Berlin", nil)
+	auth.Sign(r, nil, "station-1", []byte("station-secret"), time.Now())
+	rec = httptest.NewRecorder()
+	authorize(config.RoleStation, handler)(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, mayWriteBerlin)
+	require.False(t, mayWriteHamburg)
+}
+
+// Ensures that admin routes are closed if no credentials are configured at
+// all, while the others are open.
+func TestAuthorizeUnconfigured(t *testing.T) {
+	authCfg := config.C.Auth
+	config.C.Auth = config.AuthConfig{}
+	t.Cleanup(func() { config.C.Auth = authCfg })
+
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	for role, code := range map[string]int{
+		config.RoleRead:    http.StatusOK,
+		config.RoleStation: http.StatusOK,
+		config.RoleAdmin:   http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		authorize(role, handler)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, code, rec.Code, role)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"weather-service/internal/config"
+	"weather-service/internal/openapi"
+)
+
+// Upper limit for the body of a batch upload.
+const maxBatchSize = 16 << 20
+
+// Status of a single item of a batch upload.
+const (
+	batchAccepted = "accepted"
+	batchRejected = "rejected"
+)
+
+type BatchResult struct {
+	// Line (NDJSON) or position (JSON array) of the item, starting at 1.
+	Line   int          `json:"line"`
+	City   string       `json:"city,omitempty"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+type BatchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []BatchResult `json:"results"`
+}
+
+// postMeasurements accepts measurements of multiple cities at once. The body
+// is either a JSON array or newline-delimited JSON. Each item is a
+// measurement with an additional `city` field. Items are processed
+// independently; the response reports the outcome of each.
+func (svr *Server) postMeasurements(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r, maxBatchSize)
+	if !ok {
+		return
+	}
+
+	resp := BatchResponse{Results: []BatchResult{}}
+	now := time.Now()
+
+	// Once the broker is busy, the remaining items are rejected right away
+	// instead of each waiting for the timeout.
+	busy := false
+
+	lastLine := 0
+	process := func(line int, item []byte) {
+		lastLine = line
+
+		var result BatchResult
+		if busy {
+			result = BatchResult{Status: batchRejected, Error: ErrBrokerBusy.Error()}
+		} else {
+			var err error
+			result, err = svr.ingestBatchItem(r, item, now)
+			busy = errors.Is(err, ErrBrokerBusy)
+		}
+		result.Line = line
+
+		if result.Status == batchAccepted {
+			resp.Accepted++
+		} else {
+			resp.Rejected++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	var err error
+	if isJSONArray(r.Header.Get("Content-Type"), body) {
+		err = splitJSONArray(body, process)
+	} else {
+		err = splitNDJSON(body, process)
+	}
+
+	if err != nil {
+		// Items before the syntax error are kept, all later ones are lost.
+		resp.Rejected++
+		resp.Results = append(resp.Results, BatchResult{
+			Line:   lastLine + 1,
+			Status: batchRejected,
+			Error:  "malformed body, remaining items ignored: " + err.Error(),
+		})
+	}
+
+	requestLogger(r).Info("processed batch", "accepted", resp.Accepted, "rejected", resp.Rejected)
+	if busy {
+		setRetryAfter(w, config.C.Stream.PostTimeout)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ingestBatchItem also returns the error of storing a valid item.
+func (svr *Server) ingestBatchItem(r *http.Request, item []byte, now time.Time) (BatchResult, error) {
+	if svr.apiDoc != nil {
+		valErrs := svr.apiDoc.ValidateJSON(openapi.Ref(batchItemSchema), item, "body")
+		if len(valErrs) > 0 {
+			return BatchResult{Status: batchRejected, Error: "item does not match the API specification", Fields: fieldErrors(valErrs)}, nil
+		}
+	}
+
+	fields, fieldErr := decodeObject(item)
+	if fieldErr != nil {
+		return BatchResult{Status: batchRejected, Error: "invalid measurement", Fields: []FieldError{*fieldErr}}, nil
+	}
+
+	var city string
+	if fieldErr := decodeField(fields, "city", &city); fieldErr != nil {
+		return BatchResult{Status: batchRejected, Error: "invalid measurement", Fields: []FieldError{*fieldErr}}, nil
+	}
+	delete(fields, "city")
+
+	if !mayWrite(r, city) {
+		return BatchResult{City: city, Status: batchRejected, Error: "station may not write city"}, nil
+	}
+
+	msg, fieldErrs := svr.validateMeasurement(city, fields, now)
+	if len(fieldErrs) > 0 {
+		return BatchResult{City: city, Status: batchRejected, Error: "invalid measurement", Fields: fieldErrs}, nil
+	}
+
+	err := svr.ingest(r.Context(), city, msg)
+	if errors.Is(err, ErrConflict) {
+		return BatchResult{City: city, Status: batchRejected, Error: err.Error()}, nil
+	} else if errors.Is(err, ErrBrokerBusy) {
+		requestLogger(r).Warn("rejected measurement", "city", city, "error", err)
+		return BatchResult{City: city, Status: batchRejected, Error: err.Error()}, err
+	} else if err != nil {
+		requestLogger(r).Error("failed to store measurement", "city", city, "error", err)
+		return BatchResult{City: city, Status: batchRejected, Error: "failed to store measurement"}, err
+	}
+
+	return BatchResult{City: city, Status: batchAccepted}, nil
+}
+
+// isJSONArray decides whether a batch body is a JSON array or NDJSON. The
+// content type is preferred; otherwise, the first character is checked.
+func isJSONArray(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "application/x-ndjson", "application/jsonl":
+		return false
+	case "application/json":
+		return true
+	default:
+		return bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+	}
+}
+
+// splitJSONArray calls `process` for each element of the array.
+func splitJSONArray(body []byte, process func(pos int, item []byte)) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected array, got %v", token)
+	}
+
+	for pos := 1; decoder.More(); pos++ {
+		var item json.RawMessage
+		err = decoder.Decode(&item)
+		if err != nil {
+			return err
+		}
+		process(pos, item)
+	}
+
+	_, err = decoder.Token()
+	return err
+}
+
+// splitNDJSON calls `process` for each non-empty line.
+func splitNDJSON(body []byte, process func(line int, item []byte)) error {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, maxBatchSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		item := bytes.TrimSpace(scanner.Bytes())
+		if len(item) == 0 {
+			continue
+		}
+		process(line, item)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that the items of a JSON array are passed with their position and
+// syntax errors stop the split.
+func TestSplitJSONArray(t *testing.T) {
+	t.Parallel()
+
+	var items []string
+	process := func(pos int, item []byte) {
+		items = append(items, fmt.Sprintf("%d=%s", pos, item))
+	}
+
+	require.NoError(t, splitJSONArray([]byte(` [{"a":1}, 2 ,"x"] `), process))
+	require.Equal(t, []string{`1={"a":1}`, "2=2", `3="x"`}, items)
+
+	items = nil
+	require.Error(t, splitJSONArray([]byte(`[{"a":1}, {"b":]`), process))
+	require.Equal(t, []string{`1={"a":1}`}, items)
+
+	require.Error(t, splitJSONArray([]byte(`{"a":1}`), process))
+}
+
+// Ensures that the non-empty lines of NDJSON are passed with their line
+// number, whether valid or not.
+func TestSplitNDJSON(t *testing.T) {
+	t.Parallel()
+
+	var items []string
+	process := func(line int, item []byte) {
+		items = append(items, fmt.Sprintf("%d=%s", line, item))
+	}
+
+	require.NoError(t, splitNDJSON([]byte("{\"a\":1}\n\n  not json \r\n{\"b\":2}"), process))
+	require.Equal(t, []string{`1={"a":1}`, "3=not json", `4={"b":2}`}, items)
+}
+
+// Ensures that each item of a batch is stored or rejected on its own, and the
+// response reports the result of each line.
+func TestPostMeasurements(t *testing.T) {
+	t.Parallel()
+
+	broker := &postedBroker{posted: map[string][]Measurement{}}
+	svr := &Server{Broker: broker, store: NewMemoryStore()}
+	for _, city := range []string{"BatchCity1", "BatchCity2"} {
+		require.NoError(t, svr.store.Add(city, TempMessage{18, time.Now().Add(-time.Hour)}.Measurement()))
+	}
+
+	post := func(contentType, body string) (*httptest.ResponseRecorder, BatchResponse) {
+		r := httptest.NewRequest(http.MethodPost, "/measurements", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		svr.postMeasurements(rec, r)
+
+		var resp BatchResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		}
+		return rec, resp
+	}
+
+	ts := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	body := strings.Join([]string{
+		`{"city":"BatchCity1","temp":21,"time":"` + ts + `"}`,
+		`{"city":"BatchCity2","temp":"warm","time":"` + ts + `"}`,
+		``,
+		`not json`,
+		`{"city":"Atlantis","temp":21,"time":"` + ts + `"}`,
+		`{"city":"BatchCity2","temp":22,"time":"` + ts + `"}`,
+	}, "\n")
+
+	rec, resp := post("application/x-ndjson", body)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 2, resp.Accepted)
+	require.Equal(t, 3, resp.Rejected)
+
+	lines := map[int]string{}
+	for _, result := range resp.Results {
+		lines[result.Line] = result.Status
+	}
+	require.Equal(t, map[int]string{
+		1: batchAccepted, 2: batchRejected, 4: batchRejected, 5: batchRejected, 6: batchAccepted,
+	}, lines)
+	require.Equal(t, "temp", resp.Results[1].Fields[0].Field)
+	require.Equal(t, "city", resp.Results[3].Fields[0].Field)
+
+	require.Len(t, broker.posted["BatchCity1"], 1)
+	require.Len(t, broker.posted["BatchCity2"], 1)
+	require.InDelta(t, 22.0, broker.posted["BatchCity2"][0].Temperature.Value, 1e-9)
+
+	// Items of an array are kept up to a syntax error.
+	ts = time.Now().UTC().Format(time.RFC3339)
+	rec, resp = post("application/json", `[{"city":"BatchCity1","temp":23,"time":"`+ts+`"}, {"city":]`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, resp.Accepted)
+	require.Equal(t, 1, resp.Rejected)
+	require.Equal(t, 2, resp.Results[1].Line)
+	require.Contains(t, resp.Results[1].Error, "malformed body")
+	require.Len(t, broker.posted["BatchCity1"], 2)
+}
+
+// Ensures that items which do not match the API document only reject
+// themselves if requests are validated.
+func TestPostMeasurementsValidated(t *testing.T) {
+	t.Parallel()
+
+	spec := newAPISpec()
+	op, ok := spec.addRoute("POST /measurements", rolePublic, false)
+	require.True(t, ok)
+	spec.document()
+
+	broker := &postedBroker{posted: map[string][]Measurement{}}
+	svr := &Server{Broker: broker, store: NewMemoryStore(), apiDoc: spec.doc}
+	for _, city := range []string{"ValidCity1", "ValidCity2"} {
+		require.NoError(t, svr.store.Add(city, TempMessage{18, time.Now().Add(-time.Hour)}.Measurement()))
+	}
+	handler := validateRequest(spec.doc, op, svr.postMeasurements)
+
+	for idx, contentType := range []string{"application/x-ndjson", "application/json"} {
+		ts := time.Now().Add(-time.Duration(idx+1) * time.Minute).UTC().Format(time.RFC3339)
+		items := []string{
+			`{"city":"ValidCity1","temp":21,"time":"` + ts + `"}`,
+			`{"city":"ValidCity2","temp":21,"time":"` + ts + `","note":"unknown field"}`,
+		}
+		body := strings.Join(items, "\n")
+		if contentType == "application/json" {
+			body = "[" + strings.Join(items, ",") + "]"
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/measurements", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var resp BatchResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		require.Equal(t, 1, resp.Accepted)
+		require.Equal(t, 1, resp.Rejected)
+		require.Equal(t, batchRejected, resp.Results[1].Status)
+		require.NotEmpty(t, resp.Results[1].Fields)
+	}
+
+	require.Len(t, broker.posted["ValidCity1"], 2)
+	require.Empty(t, broker.posted["ValidCity2"])
+}
+
+// Ensures that only bodies exceeding the limit are answered with 413 and other
+// read errors with 400.
+func TestPostMeasurementsBody(t *testing.T) {
+	t.Parallel()
+
+	svr := &Server{Broker: &postedBroker{posted: map[string][]Measurement{}}, store: NewMemoryStore()}
+
+	r := httptest.NewRequest(http.MethodPost, "/measurements", strings.NewReader(strings.Repeat(" ", maxBatchSize+1)))
+	rec := httptest.NewRecorder()
+	svr.postMeasurements(rec, r)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/measurements", iotest.ErrReader(errors.New("connection reset")))
+	rec = httptest.NewRecorder()
+	svr.postMeasurements(rec, r)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
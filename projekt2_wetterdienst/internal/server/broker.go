@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Broker distributes measurements to subscribers. `Streamer` is the
+// in-process implementation; others, e.g., backed by a message queue, can be
+// passed to `Server` instead.
+type Broker interface {
+	// Post forwards the measurement of the city to its subscribers. It
+	// returns `ErrBrokerBusy` if the measurement could not be accepted before
+	// `ctx` is done.
+	Post(ctx context.Context, city string, msg Measurement) error
+
+	// Listen subscribes to the events of the city until `ctx` is done. The
+	// subscription ends right away with `ErrInvalidCity` if the city is
+	// `AllCities`, which is only a topic of `ListenCities()`.
+	Listen(ctx context.Context, city string, opts SubscribeOptions) Subscription
+
+	// ListenCities is like `Listen()` for several cities whose events are
+	// delivered in the order they were posted. All cities are included if
+	// `cities` is empty.
+	ListenCities(ctx context.Context, cities []string, opts SubscribeOptions) Subscription
+
+	// Subscribers reports the current subscriptions by city, oldest first.
+	Subscribers(ctx context.Context) ([]CitySubscribers, error)
+}
+
+// Subscription delivers the events of one or more cities.
+type Subscription interface {
+	// Events returns the channel of events. It is closed once the context of
+	// the subscription is done, the broker stops, or the subscriber was
+	// disconnected.
+	Events() <-chan Event
+
+	// Dropped returns the number of events which were not delivered as the
+	// subscriber did not keep up.
+	Dropped() uint64
+
+	// Err returns `ErrSlowConsumer` if the subscriber was disconnected or
+	// `ErrInvalidCity` if it was rejected. It must only be called once the
+	// channel of events is closed.
+	Err() error
+}
+
+// AllCities is the topic of listeners of several or all cities.
+const AllCities = "*"
+
+// ErrBrokerBusy is returned by `Post()` if the broker does not keep up with
+// measurements.
+var ErrBrokerBusy = errors.New("too many measurements are queued")
+
+// ErrInvalidCity ends subscriptions of `Listen()` to `AllCities`.
+var ErrInvalidCity = errors.New("'" + AllCities + "' is not a city")
+
+// ErrSlowConsumer ends subscriptions with the disconnect policy which dropped
+// too many events.
+var ErrSlowConsumer = errors.New("subscriber does not keep up with events")
+
+// SubscribeOptions configure subscriptions of `Listen()` and `ListenCities()`.
+type SubscribeOptions struct {
+	// If not zero, buffered events with a greater ID are delivered first.
+	After uint64
+
+	// What happens to events if the buffer of the subscription is full. One
+	// of the `config.Overflow*` policies; the configured one if empty.
+	Overflow string
+
+	// Address of the subscriber, only used for introspection.
+	RemoteAddr string
+}
+
+// Event is a measurement posted for a city.
+type Event struct {
+	// IDs increase with every posted measurement, so they are also increasing
+	// per city. The streamer starts them at the time it was first run in
+	// microseconds to keep increasing across restarts of the service.
+	ID   uint64
+	City string
+	Measurement
+
+	// Number of events of the subscription which preceded this one but were
+	// dropped as the subscriber did not keep up.
+	Missed uint64
+}
+
+// SubscriberInfo describes a subscription.
+type SubscriberInfo struct {
+	// Cities of subscriptions of several cities. All cities if empty.
+	Cities     []string  `json:"cities,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Connected  time.Time `json:"connected"`
+	Overflow   string    `json:"overflow"`
+
+	// Events which are waiting to be consumed.
+	Buffered int    `json:"buffered"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// CitySubscribers lists the subscriptions of a city. Subscriptions of several
+// cities are listed under `AllCities`.
+type CitySubscribers struct {
+	City        string           `json:"city"`
+	Subscribers []SubscriberInfo `json:"subscribers"`
+}
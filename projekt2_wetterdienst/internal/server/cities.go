@@ -0,0 +1,117 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"weather-service/internal/config"
+)
+
+// Keys by which the city listing can be sorted.
+const (
+	sortByName        = "name"
+	sortByTemperature = "temperature"
+	sortByFreshness   = "freshness"
+)
+
+// listCities returns a summary of all configured cities and of all cities with
+// measurements. Only cities whose name starts with `prefix` (ignoring case)
+// are included.
+func (svr *Server) listCities(prefix string, now time.Time) []CitySummary {
+	names := append(slices.Clone(config.C.Cities), svr.store.Cities()...)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	prefix = strings.ToLower(prefix)
+	summaries := make([]CitySummary, 0, len(names))
+
+	for _, name := range names {
+		if !strings.HasPrefix(strings.ToLower(name), prefix) {
+			continue
+		}
+
+		summary := CitySummary{
+			Name:  name,
+			Stale: true,
+		}
+
+		if latest, ok := svr.store.Latest(name); ok {
+			summary.Latest = &latest
+			summary.LastUpdate = &latest.Time
+			summary.Stale = now.Sub(latest.Time) > config.C.StaleAfter
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries
+}
+
+// sortCities sorts the summaries by the given key. Names are sorted
+// alphabetically, temperatures from lowest to highest, and freshness from
+// most to least recent, unless the order is reversed by `desc`. Cities without
+// measurements always come last.
+func sortCities(summaries []CitySummary, key string, desc bool) error {
+	// Only set values are reversed, so missing ones stay last.
+	order := func(result int) int {
+		if desc {
+			return -result
+		}
+		return result
+	}
+
+	var compare func(a, b CitySummary) int
+
+	switch key {
+	case sortByName, "":
+		compare = func(a, b CitySummary) int {
+			return order(cmp.Compare(a.Name, b.Name))
+		}
+
+	case sortByTemperature:
+		compare = func(a, b CitySummary) int {
+			aTemp, bTemp := latestTemperature(a), latestTemperature(b)
+			return compareMissing(aTemp, bTemp, func() int {
+				return order(cmp.Compare(aTemp.Value, bTemp.Value))
+			})
+		}
+
+	case sortByFreshness:
+		compare = func(a, b CitySummary) int {
+			return compareMissing(a.LastUpdate, b.LastUpdate, func() int {
+				return order(b.LastUpdate.Compare(*a.LastUpdate))
+			})
+		}
+
+	default:
+		return fmt.Errorf("invalid 'sort' parameter '%s'", key)
+	}
+
+	slices.SortStableFunc(summaries, compare)
+	return nil
+}
+
+// compareMissing orders nil values after all others and uses `compare` if
+// both values are set.
+func compareMissing[T any](a, b *T, compare func() int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	default:
+		return compare()
+	}
+}
+
+func latestTemperature(summary CitySummary) *Quantity {
+	if summary.Latest == nil {
+		return nil
+	}
+	return summary.Latest.Temperature
+}
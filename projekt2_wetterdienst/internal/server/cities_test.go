@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/config"
+)
+
+// Ensures that cities are filtered by prefix and sorted by each key in both
+// orders, with cities without measurements last in either order.
+func TestGetCities(t *testing.T) {
+	cities, staleAfter := config.C.Cities, config.C.StaleAfter
+	config.C.Cities = []string{"Berlin", "Bonn", "Köln"}
+	config.C.StaleAfter = time.Minute
+	t.Cleanup(func() { config.C.Cities, config.C.StaleAfter = cities, staleAfter })
+
+	now := time.Now()
+	svr := &Server{store: NewMemoryStore()}
+	require.NoError(t, svr.store.Add("Aachen", TempMessage{10, now.Add(-2 * time.Minute)}.Measurement()))
+	require.NoError(t, svr.store.Add("Berlin", TempMessage{15, now.Add(-30 * time.Second)}.Measurement()))
+	require.NoError(t, svr.store.Add("Bremen", TempMessage{25, now.Add(-10 * time.Second)}.Measurement()))
+
+	get := func(query string) (int, []CitySummary) {
+		rec := httptest.NewRecorder()
+		svr.getCities(rec, httptest.NewRequest(http.MethodGet, "/cities?"+query, nil))
+
+		var summaries []CitySummary
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &summaries))
+		}
+		return rec.Code, summaries
+	}
+
+	names := func(query string) []string {
+		code, summaries := get(query)
+		require.Equal(t, http.StatusOK, code, query)
+
+		names := make([]string, len(summaries))
+		for i, summary := range summaries {
+			names[i] = summary.Name
+		}
+		return names
+	}
+
+	testCases := []struct {
+		query string
+		names []string
+	}{
+		{"", []string{"Aachen", "Berlin", "Bonn", "Bremen", "Köln"}},
+		{"order=desc", []string{"Köln", "Bremen", "Bonn", "Berlin", "Aachen"}},
+		{"sort=temperature", []string{"Aachen", "Berlin", "Bremen", "Bonn", "Köln"}},
+		{"sort=temperature&order=desc", []string{"Bremen", "Berlin", "Aachen", "Bonn", "Köln"}},
+		{"sort=freshness", []string{"Bremen", "Berlin", "Aachen", "Bonn", "Köln"}},
+		{"sort=freshness&order=desc", []string{"Aachen", "Berlin", "Bremen", "Bonn", "Köln"}},
+		{"prefix=b", []string{"Berlin", "Bonn", "Bremen"}},
+		{"prefix=BR&sort=temperature", []string{"Bremen"}},
+		{"prefix=x", []string{}},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.names, names(tc.query), tc.query)
+	}
+
+	// Cities without measurements are stale.
+	code, summaries := get("units=kelvin")
+	require.Equal(t, http.StatusOK, code)
+	stale := map[string]bool{}
+	for _, summary := range summaries {
+		stale[summary.Name] = summary.Stale
+	}
+	require.Equal(t, map[string]bool{"Aachen": true, "Berlin": false, "Bonn": true, "Bremen": false, "Köln": true}, stale)
+	require.Nil(t, summaries[2].Latest)
+	require.Nil(t, summaries[2].LastUpdate)
+	require.InDelta(t, 283.15, summaries[0].Latest.Temperature.Value, 1e-9)
+
+	for _, query := range []string{"sort=wind", "order=up", "units=imperial-ish"} {
+		code, _ := get(query)
+		require.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+// Ensures that cities without measurements are sorted last by temperature and
+// freshness, whatever the order, and keep their order among each other.
+func TestSortCitiesMissing(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	withTemp := func(name string, temp int, age time.Duration) CitySummary {
+		latest := TempMessage{temp, now.Add(-age)}.Measurement()
+		return CitySummary{Name: name, Latest: &latest, LastUpdate: &latest.Time}
+	}
+
+	for _, key := range []string{sortByTemperature, sortByFreshness} {
+		for _, desc := range []bool{false, true} {
+			summaries := []CitySummary{
+				{Name: "Missing1"},
+				withTemp("Cold", 5, time.Minute),
+				{Name: "Missing2"},
+				withTemp("Warm", 25, time.Hour),
+			}
+			require.NoError(t, sortCities(summaries, key, desc))
+
+			names := make([]string, len(summaries))
+			for i, summary := range summaries {
+				names[i] = summary.Name
+			}
+			require.Equal(t, []string{"Missing1", "Missing2"}, names[2:], "%s desc=%v", key, desc)
+		}
+	}
+
+	// Humidity only: the city has data, but no temperature.
+	humid := Measurement{Version: MeasurementVersion, Time: now, Humidity: &Quantity{50, UnitPercent}}
+	summaries := []CitySummary{{Name: "Humid", Latest: &humid, LastUpdate: &humid.Time}, withTemp("Cold", 5, 0)}
+	require.NoError(t, sortCities(summaries, sortByTemperature, true))
+	require.Equal(t, "Cold", summaries[0].Name)
+}
+
+// Ensures that missing values are ordered last.
+func TestCompareMissing(t *testing.T) {
+	t.Parallel()
+
+	one, two := 1, 2
+	compare := func(a, b *int) int {
+		return compareMissing(a, b, func() int { return *a - *b })
+	}
+
+	require.Negative(t, compare(&one, &two))
+	require.Positive(t, compare(&two, &one))
+	require.Negative(t, compare(&two, nil))
+	require.Positive(t, compare(nil, &one))
+	require.Zero(t, compare(nil, nil))
+}
@@ -1,16 +1,21 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
 	"net/http"
-	"sync"
-)
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-var (
-	cities = sync.Map{}
+	"weather-service/internal/config"
 )
 
 func get(w http.ResponseWriter, r *http.Request) {
@@ -19,84 +24,515 @@ func get(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("OK"))
 }
 
-func getCitiesName(w http.ResponseWriter, r *http.Request) {
+// Readiness checks taking longer fail.
+const readyTimeout = 2 * time.Second
+
+// getHealthz reports that the process is alive and able to serve requests.
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// getReadyz reports whether all services are running and pass their checks.
+func (svr *Server) getReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	report := svr.Services.Check(ctx)
+
+	// The endpoint is public, so the details of errors are only logged.
+	for i := range report.Services {
+		svcStatus := &report.Services[i]
+		failed := len(svcStatus.Check) > 0 && svcStatus.Check != "ok"
+		if failed || len(svcStatus.Error) > 0 {
+			requestLogger(r).Warn("service is not ready", "service", svcStatus.Name, "state", svcStatus.State,
+				"error", svcStatus.Error, "check", svcStatus.Check)
+		}
+
+		if failed {
+			svcStatus.Check = "failed"
+		}
+		svcStatus.Error = ""
+	}
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func (svr *Server) getCities(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	summaries := svr.listCities(query.Get("prefix"), time.Now())
+
+	units, err := parseUnitSystem(r)
+	if err == nil {
+		switch order := query.Get("order"); order {
+		case "", "asc":
+			err = sortCities(summaries, query.Get("sort"), false)
+		case "desc":
+			err = sortCities(summaries, query.Get("sort"), true)
+		default:
+			err = fmt.Errorf("invalid 'order' parameter '%s'", order)
+		}
+	}
+
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for i := range summaries {
+		if summaries[i].Latest != nil {
+			latest := units.convertMeasurement(*summaries[i].Latest)
+			summaries[i].Latest = &latest
+		}
+	}
+
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (svr *Server) getCitiesName(w http.ResponseWriter, r *http.Request) {
 	cityName := r.PathValue("name")
 
-	measurement, ok := cities.Load(cityName)
+	units, err := parseUnitSystem(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	measurement, ok := svr.store.Latest(cityName)
 	if !ok {
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	jsonData, err := json.Marshal(measurement)
-	if err != nil {
-		fmt.Println("ERROR: failed to marshall measurement:", err)
+	measurement = units.convertMeasurement(measurement)
+	if wantsText(r) {
+		writeText(w, http.StatusOK, formatMeasurement(measurement, parseLanguage(r)))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, measurement)
+}
+
+// Upper limit for the body of a single measurement.
+const maxMeasurementSize = 64 << 10
+
+func (svr *Server) postCitiesName(w http.ResponseWriter, r *http.Request) {
+	cityName := r.PathValue("name")
+
+	body, ok := readBody(w, r, maxMeasurementSize)
+	if !ok {
+		return
+	}
+
+	if !mayWrite(r, cityName) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "station may not write city '" + cityName + "'"})
+		return
+	}
+
+	msg, fieldErrs := svr.parseMeasurement(cityName, body, time.Now())
+	if len(fieldErrs) > 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{"invalid measurement", fieldErrs})
+		return
+	}
+
+	requestLogger(r).Debug("received measurement", "city", cityName, "body", string(body))
+
+	err := svr.ingest(r.Context(), cityName, msg)
+	if errors.Is(err, ErrConflict) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: err.Error()})
+		return
+	} else if errors.Is(err, ErrBrokerBusy) {
+		requestLogger(r).Warn("rejected measurement", "city", cityName, "error", err)
+		setRetryAfter(w, config.C.Stream.PostTimeout)
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: err.Error()})
+		return
+	} else if err != nil {
+		requestLogger(r).Error("failed to store measurement", "city", cityName, "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(jsonData)
 }
 
-func postCitiesName(w http.ResponseWriter, r *http.Request) {
+func (svr *Server) getCitiesNameHistory(w http.ResponseWriter, r *http.Request) {
 	cityName := r.PathValue("name")
 
-	body, err := io.ReadAll(r.Body)
-	fmt.Println("POST", cityName, string(body))
+	from, to, rangeErr := parseTimeRange(r)
+	units, unitsErr := parseUnitSystem(r)
+
+	err := errors.Join(rangeErr, unitsErr)
 	if err != nil {
-		fmt.Println("ERROR: failed to read request body:", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeText(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// TODO: Evaluate input.
+	history, ok := svr.store.History(cityName, from, to)
+	if !ok {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	for i := range history {
+		history[i] = units.convertMeasurement(history[i])
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (svr *Server) getCitiesNameAggregate(w http.ResponseWriter, r *http.Request) {
+	cityName := r.PathValue("name")
+
+	from, to, rangeErr := parseTimeRange(r)
+	window, windowErr := parseWindow(r)
+	units, unitsErr := parseUnitSystem(r)
+
+	rdName := r.URL.Query().Get("reading")
+	if len(rdName) == 0 {
+		rdName = "temperature"
+	}
+	rd, ok := findReading(rdName)
 
-	var msg TempMessage
-	err = json.Unmarshal(body, &msg)
+	var readingErr error
+	if !ok {
+		readingErr = fmt.Errorf("invalid 'reading' parameter '%s'", rdName)
+	}
+
+	err := errors.Join(rangeErr, windowErr, unitsErr, readingErr)
 	if err != nil {
-		fmt.Println("ERROR: failed to unmarshal request body:", err)
-		w.WriteHeader(http.StatusBadRequest)
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	history, ok := svr.store.History(cityName, from, to)
+	if !ok {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	cities.Store(cityName, msg)
-	Post(cityName, msg)
+	allStats := aggregate(history, rd, window)
+	for i := range allStats {
+		allStats[i] = units.convertStats(allStats[i])
+	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, allStats)
 }
 
-func getCitiesNameStream(w http.ResponseWriter, r *http.Request) {
+// getCitiesNameStream sends the measurements of a city as server-sent events.
+func (svr *Server) getCitiesNameStream(w http.ResponseWriter, r *http.Request) {
 	cityName := r.PathValue("name")
+	if cityName == AllCities {
+		writeText(w, http.StatusBadRequest, "use '/stream?cities=*' to stream all cities")
+		return
+	}
+
+	units, err := parseUnitSystem(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, err := subscribeOptions(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	msgChan := Listen(ctx, cityName)
+	sub := svr.Broker.Listen(ctx, cityName, opts)
+	streamEvents(w, r, sub, func(event Event) any {
+		return units.convertMeasurement(event.Measurement)
+	})
+}
+
+// StreamEvent is the data of events of the multiplexed stream.
+type StreamEvent struct {
+	City        string      `json:"city"`
+	Measurement Measurement `json:"measurement"`
+}
 
+// getStream sends the measurements of several cities, or of all cities if
+// `cities` is "*", as server-sent events over a single connection.
+func (svr *Server) getStream(w http.ResponseWriter, r *http.Request) {
+	units, err := parseUnitSystem(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var cities []string
+	switch param := r.URL.Query().Get("cities"); param {
+	case "":
+		writeText(w, http.StatusBadRequest, "missing cities, e.g., 'cities=Berlin,Hamburg' or 'cities=*'")
+		return
+	case AllCities:
+	default:
+		for _, city := range strings.Split(param, ",") {
+			city = strings.TrimSpace(city)
+			if len(city) == 0 {
+				writeText(w, http.StatusBadRequest, "empty city in 'cities'")
+				return
+			}
+			cities = append(cities, city)
+		}
+	}
+
+	opts, err := subscribeOptions(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	sub := svr.Broker.ListenCities(ctx, cities, opts)
+	streamEvents(w, r, sub, func(event Event) any {
+		return StreamEvent{event.City, units.convertMeasurement(event.Measurement)}
+	})
+}
+
+// StreamGap is the data of events which announce that measurements were
+// dropped as the client did not keep up.
+type StreamGap struct {
+	Missed uint64 `json:"missed"`
+}
+
+// subscribeOptions reads the optional `overflow` query parameter and the ID
+// of the last event a reconnecting client received. Invalid IDs are ignored;
+// IDs older than the buffer replay all of it.
+func subscribeOptions(r *http.Request) (SubscribeOptions, error) {
+	opts := SubscribeOptions{RemoteAddr: r.RemoteAddr}
+	opts.After, _ = strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	switch opts.Overflow = r.URL.Query().Get("overflow"); opts.Overflow {
+	case "", config.OverflowDropNewest, config.OverflowDropOldest, config.OverflowDisconnect:
+	default:
+		return opts, fmt.Errorf("unknown overflow policy '%s'", opts.Overflow)
+	}
+
+	return opts, nil
+}
+
+// streamEvents sends server-sent events with the data returned by `data` as
+// JSON. Each event carries its ID, so reconnecting clients can resume the
+// stream with the `Last-Event-ID` header. Dropped events are announced by
+// "gap" events. Comments are sent regularly to keep idle connections open.
+func streamEvents(w http.ResponseWriter, r *http.Request, sub Subscription, data func(Event) any) {
 	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Prevents proxies like nginx from buffering the events.
+	w.Header().Set("X-Accel-Buffering", "no")
 	w.WriteHeader(http.StatusOK)
 
-	for done := false; !done; {
-		var msg TempMessage
+	rc := http.NewResponseController(w)
+	write := func(content string) error {
+		_, err := io.WriteString(w, content)
+		if err == nil {
+			err = rc.Flush()
+		}
+		return err
+	}
+
+	err := write(fmt.Sprintf("retry: %d\n\n", config.C.Stream.RetryInterval.Milliseconds()))
+
+	ticker := time.NewTicker(config.C.Stream.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for err == nil {
 		select {
-		case <-ctx.Done():
-			done = true
-			continue
+		case <-r.Context().Done():
+			return
 
-		case msg = <-msgChan:
+		case event, ok := <-sub.Events():
+			if !ok {
+				// The streamer stopped or the client was too slow. In the
+				// latter case, it can resume the stream after reconnecting.
+				requestLogger(r).Debug("stream ended", "dropped", sub.Dropped(), "error", sub.Err())
+				return
+			}
+
+			if event.Missed > 0 {
+				jsonGap, _ := json.Marshal(StreamGap{event.Missed})
+				err = write(fmt.Sprintf("event: gap\ndata: %s\n\n", jsonGap))
+			}
+			if err == nil {
+				jsonMsg, _ := json.Marshal(data(event))
+				err = write(fmt.Sprintf("id: %d\ndata: %s\n\n", event.ID, jsonMsg))
+			}
+
+		case <-ticker.C:
+			err = write(": heartbeat\n\n")
 		}
+	}
+
+	requestLogger(r).Debug("stream closed", "dropped", sub.Dropped(), "error", err)
+}
+
+// getAdminSubscribers reports the current subscribers of event streams and
+// WebSockets by city.
+func (svr *Server) getAdminSubscribers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	allSubs, err := svr.Broker.Subscribers(ctx)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, allSubs)
+}
+
+// postAdminCompact compacts the write-ahead log immediately.
+func (svr *Server) postAdminCompact(w http.ResponseWriter, r *http.Request) {
+	if svr.wal == nil {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: "measurements are not persisted"})
+		return
+	}
 
-		jsonMsg, _ := json.Marshal(msg)
-		content := string(jsonMsg)
+	err := svr.wal.Compact()
+	if err != nil {
+		requestLogger(r).Error("failed to compact storage", "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "failed to compact storage"})
+		return
+	}
 
-		_, err := w.Write([]byte("data: " + content + "\n\n"))
+	w.WriteHeader(http.StatusOK)
+}
+
+// getAdminRateLimits reports the requests rejected by each rate limiter.
+func (svr *Server) getAdminRateLimits(w http.ResponseWriter, r *http.Request) {
+	allStats := make([]RateLimitStats, 0, len(svr.limiters))
+	for _, limiter := range svr.limiters {
+		allStats = append(allStats, limiter.stats())
+	}
+
+	slices.SortFunc(allStats, func(a, b RateLimitStats) int {
+		return cmp.Compare(a.Route, b.Route)
+	})
+
+	writeJSON(w, http.StatusOK, allStats)
+}
+
+// ingest stores a valid measurement and forwards it to all listeners.
+// Duplicates are not stored again but still forwarded, so clients can retry
+// measurements which were stored while the broker was busy. Measurements
+// which conflict with a stored one are neither stored nor forwarded.
+func (svr *Server) ingest(ctx context.Context, city string, msg Measurement) error {
+	err := svr.store.Add(city, msg)
+	if err != nil && !errors.Is(err, ErrDuplicate) {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.C.Stream.PostTimeout)
+	defer cancel()
+
+	return svr.Broker.Post(ctx, city, msg)
+}
+
+// parseTimeRange reads the optional `from` and `to` query parameters as
+// RFC 3339 timestamps. Missing parameters result in zero times.
+func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	var times [2]time.Time
+
+	for i, param := range []string{"from", "to"} {
+		value := r.URL.Query().Get(param)
+		if len(value) == 0 {
+			continue
+		}
+
+		ts, err := time.Parse(time.RFC3339, value)
 		if err != nil {
-			fmt.Println("ERROR: failed to marshal data:", err)
-			break
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid '%s' parameter: %w", param, err)
 		}
-		w.(http.Flusher).Flush()
+		times[i] = ts
+	}
+
+	if !times[0].IsZero() && !times[1].IsZero() && times[1].Before(times[0]) {
+		return time.Time{}, time.Time{}, errors.New("'to' must not be before 'from'")
+	}
+
+	return times[0], times[1], nil
+}
+
+// parseWindow reads the `window` query parameter as a duration. It defaults to
+// one hour if missing.
+func parseWindow(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("window")
+	if len(value) == 0 {
+		return time.Hour, nil
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid 'window' parameter: %w", err)
+	}
+	if window <= 0 {
+		return 0, errors.New("'window' must be positive")
+	}
+
+	return window, nil
+}
+
+// writeJSON sends `data` as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, statusCode int, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		logger.Error("failed to marshal response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(jsonData)
+}
+
+// writeText sends a plain text message with the given status code.
+func writeText(w http.ResponseWriter, statusCode int, text string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(text))
+}
+
+// readBody reads the body of the request up to `limit` bytes. Otherwise, it
+// answers with 413 if the body is larger or 400 if it cannot be read.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeText(w, http.StatusRequestEntityTooLarge, err.Error())
+		return nil, false
+	} else if err != nil {
+		requestLogger(r).Debug("failed to read request body", "error", err)
+		writeText(w, http.StatusBadRequest, "failed to read body")
+		return nil, false
 	}
+
+	return body, true
+}
+
+// wantsText reports whether the client prefers a plain text response over
+// JSON, i.e., lists `text/plain` first in the `Accept` header.
+func wantsText(r *http.Request) bool {
+	first, _, _ := strings.Cut(r.Header.Get("Accept"), ",")
+	mediaType, _, _ := mime.ParseMediaType(first)
+	return mediaType == "text/plain"
+}
+
+// setRetryAfter tells the client how long to wait before the next request, in
+// whole seconds but at least one.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
 }
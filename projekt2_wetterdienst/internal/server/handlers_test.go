@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/services"
+)
+
+// postedBroker records posted measurements instead of distributing them. If
+// busy, it rejects them once the context is done.
+type postedBroker struct {
+	mu     sync.Mutex
+	posted map[string][]Measurement
+	busy   bool
+}
+
+func (pb *postedBroker) Post(ctx context.Context, city string, msg Measurement) error {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if pb.busy {
+		<-ctx.Done()
+		return ErrBrokerBusy
+	}
+
+	pb.posted[city] = append(pb.posted[city], msg)
+	return nil
+}
+
+func (pb *postedBroker) Listen(ctx context.Context, city string, opts SubscribeOptions) Subscription {
+	panic("not implemented")
+}
+
+func (pb *postedBroker) ListenCities(ctx context.Context, cities []string, opts SubscribeOptions) Subscription {
+	panic("not implemented")
+}
+
+func (pb *postedBroker) Subscribers(ctx context.Context) ([]CitySubscribers, error) {
+	return nil, nil
+}
+
+// Ensures that submitted measurements are stored and passed to the broker of
+// the server, and rejected ones are not. Measurements are rejected with 503 if
+// the broker is busy, but their retries are not stored twice. Different
+// measurements of the same time are rejected with 409.
+func TestPostCitiesName(t *testing.T) {
+	t.Parallel()
+
+	broker := &postedBroker{posted: map[string][]Measurement{}}
+	svr := &Server{Broker: broker, store: NewMemoryStore()}
+	require.NoError(t, svr.store.Add("BrokerCity", TempMessage{18, time.Now().Add(-time.Hour)}.Measurement()))
+
+	post := func(city, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/cities/"+city, strings.NewReader(body))
+		r.SetPathValue("name", city)
+		rec := httptest.NewRecorder()
+		svr.postCitiesName(rec, r)
+		return rec
+	}
+
+	body := `{"temp":21,"time":"` + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339) + `"}`
+	require.Equal(t, http.StatusOK, post("BrokerCity", body).Code)
+	require.Equal(t, http.StatusBadRequest, post("BrokerCity", `{"temp":"warm"}`).Code)
+	require.Equal(t, http.StatusBadRequest, post("Atlantis", body).Code)
+
+	require.Len(t, broker.posted, 1)
+	require.Len(t, broker.posted["BrokerCity"], 1)
+	require.InDelta(t, 21.0, broker.posted["BrokerCity"][0].Temperature.Value, 1e-9)
+
+	latest, ok := svr.store.Latest("BrokerCity")
+	require.True(t, ok)
+	require.Equal(t, broker.posted["BrokerCity"][0], latest)
+
+	// Retries of measurements stored while the broker was busy are only
+	// forwarded.
+	body = `{"temp":22,"time":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
+	broker.busy = true
+	rec := post("BrokerCity", body)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	require.Len(t, broker.posted["BrokerCity"], 1)
+
+	broker.busy = false
+	require.Equal(t, http.StatusOK, post("BrokerCity", body).Code)
+	require.Len(t, broker.posted["BrokerCity"], 2)
+
+	history, ok := svr.store.History("BrokerCity", time.Time{}, time.Time{})
+	require.True(t, ok)
+	require.Len(t, history, 3)
+
+	// Different measurements of a stored time are neither stored nor
+	// forwarded.
+	rec = post("BrokerCity", strings.Replace(body, `"temp":22`, `"temp":23`, 1))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Len(t, broker.posted["BrokerCity"], 2)
+
+	latest, ok = svr.store.Latest("BrokerCity")
+	require.True(t, ok)
+	require.InDelta(t, 22.0, latest.Temperature.Value, 1e-9)
+}
+
+// Ensures that bodies exceeding the limit are answered with 413 and other read
+// errors with 400, also when submitting single measurements or signed
+// requests.
+func TestReadBody(t *testing.T) {
+	t.Parallel()
+
+	read := func(body io.Reader) (*httptest.ResponseRecorder, []byte, bool) {
+		rec := httptest.NewRecorder()
+		data, ok := readBody(rec, httptest.NewRequest(http.MethodPost, "/", body), 4)
+		return rec, data, ok
+	}
+
+	_, data, ok := read(strings.NewReader("1234"))
+	require.True(t, ok)
+	require.Equal(t, "1234", string(data))
+
+	rec, _, ok := read(strings.NewReader("12345"))
+	require.False(t, ok)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	rec, _, ok = read(iotest.ErrReader(errors.New("connection reset")))
+	require.False(t, ok)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	svr := &Server{Broker: &postedBroker{posted: map[string][]Measurement{}}, store: NewMemoryStore()}
+	r := httptest.NewRequest(http.MethodPost, "/cities/Berlin", strings.NewReader(strings.Repeat(" ", maxMeasurementSize+1)))
+	r.SetPathValue("name", "Berlin")
+	rec = httptest.NewRecorder()
+	svr.postCitiesName(rec, r)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+// idleService runs until its context is done.
+type idleService struct{}
+
+func (idleService) Name() string                  { return "Idle" }
+func (idleService) Init(_ context.Context) error  { return nil }
+func (idleService) Run(ctx context.Context) error { <-ctx.Done(); return nil }
+func (idleService) Stop() error                   { return nil }
+
+// Ensures that each server reports the services of its own registry.
+func TestGetReadyz(t *testing.T) {
+	t.Parallel()
+
+	running := &Server{Services: &services.Registry{}}
+	idle := &Server{Services: &services.Registry{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- services.Run(ctx, []services.Service{idleService{}}, running.Services) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	readyz := func(svr *Server) (int, services.Report) {
+		rec := httptest.NewRecorder()
+		svr.getReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var report services.Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		return rec.Code, report
+	}
+
+	require.Eventually(t, func() bool {
+		code, _ := readyz(running)
+		return code == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	code, report := readyz(idle)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Empty(t, report.Services)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"weather-service/internal/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter("weather_http_requests_total",
+		"Number of handled HTTP requests.", "method", "route", "code")
+	httpDuration = metrics.NewHistogram("weather_http_request_duration_seconds",
+		"Time until HTTP requests were handled.", metrics.DefBuckets, "method", "route")
+
+	streamSubscribers = metrics.NewGauge("weather_stream_subscribers",
+		"Number of listeners subscribed to measurements of a city.", "city")
+	streamDropped = metrics.NewCounter("weather_stream_dropped_messages_total",
+		"Number of measurements not delivered to listeners as their buffer was full.", "city")
+	streamQueued = metrics.NewGauge("weather_stream_post_queue_length",
+		"Number of measurements waiting to be distributed by the streamer.")
+	streamQueueTime = metrics.NewHistogram("weather_stream_post_queue_seconds",
+		"Time measurements waited to be distributed by the streamer.", metrics.DefBuckets)
+	streamRejected = metrics.NewCounter("weather_stream_post_rejected_total",
+		"Number of measurements rejected as the post queue was full.")
+	streamDisconnects = metrics.NewCounter("weather_stream_disconnected_subscribers_total",
+		"Number of subscribers disconnected as they dropped too many measurements.", "city")
+
+	wsConnections = metrics.NewGauge("weather_websocket_connections",
+		"Number of open WebSocket connections.")
+
+	rateLimited = metrics.NewCounter("weather_ratelimit_throttled_total",
+		"Number of requests rejected by rate limits.", "route")
+)
+
+// instrument records the number and duration of requests by route. It must
+// see the same request as the router, as the router sets its pattern.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rr := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rr, r)
+
+		// Requests without matching route are grouped to limit the number of
+		// series.
+		route := r.Pattern
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+
+		httpRequests.Inc(r.Method, route, strconv.Itoa(rr.statusCode()))
+		httpDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Middleware wraps a handler to add behaviour to all requests passing it.
+type Middleware func(next http.Handler) http.Handler
+
+// chain wraps the handler with the given middlewares. The first middleware
+// is the outermost one, i.e., it sees requests first.
+func chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// recovery turns panics of handlers into 500 responses instead of dropping
+// the connection.
+func recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			// Used by handlers to deliberately abort a response.
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+
+			requestLogger(r).Error("panic in handler",
+				"method", r.Method, "path", r.URL.Path, "panic", rec, "stack", string(debug.Stack()))
+
+			// Only possible if the handler did not write anything yet.
+			if rr, ok := w.(*responseRecorder); !ok || rr.status == 0 {
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal server error"})
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestID attaches an ID to each request which is taken from the
+// X-Request-ID header or generated if missing. It is returned with the
+// response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// accessLog logs each completed request.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rr := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rr, r)
+
+		requestLogger(r).Info("request",
+			"remote", r.RemoteAddr, "method", r.Method, "uri", r.URL.RequestURI(),
+			"status", rr.statusCode(), "bytes", rr.bytes, "duration", time.Since(start))
+	})
+}
+
+// requestLogger returns a logger which adds the ID of the request.
+func requestLogger(r *http.Request) *slog.Logger {
+	return logger.With("requestId", RequestID(r.Context()))
+}
+
+// RequestID returns the ID of the request with the given context.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// isValidRequestID only accepts short IDs of printable ASCII characters so
+// they can safely be logged.
+func isValidRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range []byte(id) {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// responseRecorder records the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if rr.status == 0 {
+		rr.status = statusCode
+	}
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+
+	n, err := rr.ResponseWriter.Write(data)
+	rr.bytes += n
+	return n, err
+}
+
+// Flush is required by streaming handlers.
+func (rr *responseRecorder) Flush() {
+	_ = http.NewResponseController(rr.ResponseWriter).Flush()
+}
+
+// Unwrap gives `http.ResponseController` access to the original writer.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+func (rr *responseRecorder) statusCode() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
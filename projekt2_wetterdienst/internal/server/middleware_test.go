@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that panics are turned into 500 responses which still carry the
+// request ID.
+func TestMiddlewareRecovery(t *testing.T) {
+	t.Parallel()
+
+	handler := chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") }),
+		requestID, accessLog, recovery,
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, "abc-123", rec.Header().Get(requestIDHeader))
+}
+
+// Ensures that invalid request IDs are replaced and that the ID is available
+// to handlers.
+func TestMiddlewareRequestID(t *testing.T) {
+	t.Parallel()
+
+	var seenID string
+	handler := chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seenID = RequestID(r.Context()) }),
+		requestID,
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "contains spaces")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	require.Len(t, seenID, 32)
+	require.Equal(t, seenID, rec.Header().Get(requestIDHeader))
+}
@@ -0,0 +1,523 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"weather-service/internal/config"
+	"weather-service/internal/openapi"
+	"weather-service/internal/services"
+)
+
+// Version of the API as reported by the OpenAPI document.
+const apiVersion = "2.0.0"
+
+// Upper limit for bodies of requests to operations which do not document one.
+const maxUndocumentedBodySize = 1 << 10
+
+// Name of the schema of the items of a batch upload. They are validated one by
+// one by `postMeasurements()`, so an invalid item only rejects itself.
+const batchItemSchema = "BatchItem"
+
+//go:embed docs.html
+var docsPage []byte
+
+// Names of the security schemes.
+const (
+	securityBearer    = "bearerToken"
+	securitySignature = "stationSignature"
+)
+
+// apiSpec documents the routes of the server. Operations are added by
+// `addRoute()` when routes are registered.
+type apiSpec struct {
+	doc *openapi.Document
+	gen *openapi.Generator
+
+	operations map[string]*openapi.Operation
+}
+
+func newAPISpec() *apiSpec {
+	gen := openapi.NewGenerator()
+
+	allUnits := []any{}
+	for unit := range units {
+		allUnits = append(allUnits, string(unit))
+	}
+	slices.SortFunc(allUnits, func(a, b any) int { return strings.Compare(a.(string), b.(string)) })
+	openapi.Override[Unit](gen, openapi.String("", allUnits...))
+
+	openapi.Override[services.State](gen, openapi.String("",
+		services.StatePending, services.StateInitialized, services.StateRunning,
+		services.StateStopping, services.StateStopped, services.StateFailed,
+	))
+
+	spec := &apiSpec{
+		doc: &openapi.Document{
+			OpenAPI: openapi.Version,
+			Info: openapi.Info{
+				Title:       "Wetterdienst",
+				Description: "Collects measurements of weather stations and provides them to clients.",
+				Version:     apiVersion,
+			},
+			Paths: map[string]*openapi.PathItem{},
+		},
+		gen: gen,
+	}
+	spec.operations = spec.describeRoutes()
+
+	return spec
+}
+
+// addRoute adds the operation of the given route to the document. Responses
+// of the authorization and the rate limiter are added as needed.
+func (spec *apiSpec) addRoute(pattern, role string, limited bool) (*openapi.Operation, bool) {
+	op, ok := spec.operations[pattern]
+	if !ok {
+		return nil, false
+	}
+
+	method, path, _ := strings.Cut(pattern, " ")
+	method = strings.ToLower(method)
+
+	if role != rolePublic && config.C.Auth.Required(role) {
+		op.Security = []map[string][]string{{securityBearer: {}}}
+		if role == config.RoleStation {
+			op.Security = append(op.Security, map[string][]string{securitySignature: {}})
+		}
+
+		op.Responses["401"] = errorResponse("Missing or invalid credentials.")
+		op.Responses["403"] = errorResponse("The client's role or cities do not permit the request.")
+	}
+
+	if limited {
+		resp := errorResponse("The client exceeded the rate limit.")
+		resp.Headers = map[string]openapi.Header{
+			"Retry-After": {Description: "Seconds until the next request is allowed.", Schema: &openapi.Schema{Type: "integer"}},
+		}
+		op.Responses["429"] = resp
+	}
+
+	if config.C.OpenAPI.ValidateRequests && (len(op.Parameters) > 0 || op.RequestBody != nil && !validatesItems(op)) {
+		op.Responses["400"] = errorResponse("The request does not match this document.")
+	}
+
+	item, ok := spec.doc.Paths[path]
+	if !ok {
+		item = &openapi.PathItem{}
+		spec.doc.Paths[path] = item
+	}
+	(*item)[method] = op
+
+	return op, true
+}
+
+// document completes the document after all routes are added.
+func (spec *apiSpec) document() *openapi.Document {
+	spec.doc.Components.Schemas = spec.gen.Schemas
+
+	if config.C.Auth.Enabled() {
+		spec.doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+			securityBearer: {
+				Type: "http", Scheme: "bearer",
+				Description: "Token of a client as configured in `auth.tokens`.",
+			},
+			securitySignature: {
+				Type: "apiKey", In: "header", Name: "X-Station-ID",
+				Description: "ID of a station which signs requests with HMAC-SHA256 in the " +
+					"`X-Signature` header over method, URI, `X-Timestamp` and body. " +
+					"Stations can also authenticate with client certificates.",
+			},
+		}
+	}
+
+	return spec.doc
+}
+
+// describeRoutes returns the operations of all routes by pattern.
+func (spec *apiSpec) describeRoutes() map[string]*openapi.Operation {
+	gen := spec.gen
+
+	measurement := openapi.SchemaFor[Measurement](gen)
+	summaries := openapi.SchemaFor[[]CitySummary](gen)
+	history := openapi.SchemaFor[[]Measurement](gen)
+	stats := openapi.SchemaFor[[]WindowStats](gen)
+
+	// Referenced by `errorResponse()` and descriptions.
+	openapi.SchemaFor[ErrorResponse](gen)
+	openapi.SchemaFor[WSRequest](gen)
+	openapi.SchemaFor[WSEvent](gen)
+	openapi.SchemaFor[StreamEvent](gen)
+	openapi.SchemaFor[StreamGap](gen)
+
+	// Submitted measurements may omit the version and must not contain
+	// other fields. The `TempMessage` is accepted as well.
+	openapi.SchemaFor[TempMessage](gen)
+	gen.Schemas["MeasurementInput"] = gen.Schemas["Measurement"].Closed(map[string]*openapi.Schema{
+		"version": {Type: "integer", Enum: []any{MeasurementVersion}},
+	})
+	gen.Schemas["MeasurementInput"].Required = []string{"time"}
+	gen.Schemas["TempMessage"] = gen.Schemas["TempMessage"].Closed(map[string]*openapi.Schema{
+		"version": {Type: "integer", Enum: []any{1}},
+	})
+
+	input := &openapi.Schema{OneOf: []*openapi.Schema{openapi.Ref("MeasurementInput"), openapi.Ref("TempMessage")}}
+
+	city := map[string]*openapi.Schema{"city": {Type: "string"}}
+	gen.Schemas[batchItemSchema] = &openapi.Schema{OneOf: []*openapi.Schema{
+		gen.Schemas["MeasurementInput"].Closed(city, "city"),
+		gen.Schemas["TempMessage"].Closed(city, "city"),
+	}}
+	batchItem := openapi.Ref(batchItemSchema)
+
+	readingNames := []any{}
+	for _, rd := range readings {
+		readingNames = append(readingNames, rd.name)
+	}
+
+	return map[string]*openapi.Operation{
+		"GET /": {
+			Summary:   "Reports that the server is running.",
+			Tags:      []string{"health"},
+			Responses: map[string]openapi.Response{"200": textResponse("Always \"OK\".")},
+		},
+		"GET /healthz": {
+			Summary: "Reports that the process is alive.",
+			Tags:    []string{"health"},
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("The process is alive.", &openapi.Schema{
+					Type:       "object",
+					Properties: map[string]*openapi.Schema{"status": openapi.String("", "ok")},
+				}),
+			},
+		},
+		"GET /readyz": {
+			Summary: "Reports whether all services are running and pass their checks.",
+			Tags:    []string{"health"},
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("All services are ready.", openapi.SchemaFor[services.Report](gen)),
+				"503": jsonResponse("At least one service is not ready.", openapi.SchemaFor[services.Report](gen)),
+			},
+		},
+		"GET /metrics": {
+			Summary:   "Metrics in the Prometheus text exposition format.",
+			Tags:      []string{"health"},
+			Responses: map[string]openapi.Response{"200": textResponse("Current metrics.")},
+		},
+		"GET /openapi.json": {
+			Summary:   "This document.",
+			Tags:      []string{"documentation"},
+			Responses: map[string]openapi.Response{"200": jsonResponse("The OpenAPI document.", &openapi.Schema{Type: "object"})},
+		},
+		"GET /docs": {
+			Summary: "Interactive explorer of this document.",
+			Tags:    []string{"documentation"},
+			Responses: map[string]openapi.Response{
+				"200": {Description: "HTML page.", Content: map[string]openapi.MediaType{"text/html": {Schema: openapi.String("")}}},
+			},
+		},
+		"GET /cities": {
+			Summary: "Lists all cities with their latest measurement.",
+			Tags:    []string{"cities"},
+			Parameters: append([]openapi.Parameter{
+				{Name: "prefix", In: openapi.InQuery, Description: "Only cities whose name starts with the prefix (case-insensitive).", Schema: openapi.String("")},
+				{Name: "sort", In: openapi.InQuery, Description: "Sort key.", Schema: openapi.String("", sortByName, sortByTemperature, sortByFreshness)},
+				{Name: "order", In: openapi.InQuery, Description: "Sort order. Cities without data come last in either order.", Schema: openapi.String("", "asc", "desc")},
+			}, unitParams()...),
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("Summaries of all cities.", summaries),
+				"400": textResponse("Invalid query parameters."),
+			},
+		},
+		"GET /cities/{name}": {
+			Summary:    "Returns the latest measurement of a city.",
+			Tags:       []string{"cities"},
+			Parameters: append([]openapi.Parameter{cityParam(), languageParam()}, unitParams()...),
+			Responses: map[string]openapi.Response{
+				"200": {
+					Description: "The latest measurement. As text with one line per reading if `text/plain` is accepted first.",
+					Content: map[string]openapi.MediaType{
+						"application/json": {Schema: measurement},
+						"text/plain":       {Schema: openapi.String("")},
+					},
+				},
+				"400": textResponse("Invalid query parameters."),
+				"404": {Description: "No measurements of the city."},
+			},
+		},
+		"POST /cities/{name}": {
+			Summary:    "Submits a measurement of a city.",
+			Tags:       []string{"stations"},
+			Parameters: []openapi.Parameter{cityParam()},
+			RequestBody: &openapi.RequestBody{
+				Description: "A measurement in the current format or a `TempMessage`. Readings may use any unit of the same dimension.",
+				Required:    true,
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: input}},
+			},
+			Responses: map[string]openapi.Response{
+				"200": {Description: "The measurement was stored."},
+				"400": errorResponse("The measurement is invalid."),
+				"409": errorResponse("A different measurement of this time is stored already."),
+				"503": busyResponse(),
+			},
+		},
+		"POST /measurements": {
+			Summary: "Submits measurements of multiple cities.",
+			Tags:    []string{"stations"},
+			RequestBody: &openapi.RequestBody{
+				Description: "A JSON array or newline-delimited JSON of measurements with their city.",
+				Required:    true,
+				Content: map[string]openapi.MediaType{
+					"application/json":     {Schema: &openapi.Schema{Type: "array", Items: batchItem}},
+					"application/x-ndjson": {Schema: batchItem},
+				},
+			},
+			Responses: map[string]openapi.Response{
+				"200": retryResponse(jsonResponse("The outcome of each item. Once the streamer is busy, "+
+					"the remaining items are rejected.", openapi.SchemaFor[BatchResponse](gen))),
+				"400": textResponse("The body could not be split into items."),
+				"413": textResponse("The body is too large."),
+			},
+		},
+		"GET /cities/{name}/history": {
+			Summary:    "Returns the measurements of a city in a time range.",
+			Tags:       []string{"cities"},
+			Parameters: append(append([]openapi.Parameter{cityParam()}, timeRangeParams()...), unitParams()...),
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("Measurements ordered by time.", history),
+				"400": textResponse("Invalid query parameters."),
+				"404": {Description: "No measurements of the city."},
+			},
+		},
+		"GET /cities/{name}/aggregate": {
+			Summary: "Returns statistics of a reading of a city per time window.",
+			Tags:    []string{"cities"},
+			Parameters: append(append([]openapi.Parameter{
+				cityParam(),
+				{Name: "window", In: openapi.InQuery, Description: "Length of the windows as Go duration, e.g., \"15m\". Defaults to 1h.", Schema: openapi.String("")},
+				{Name: "reading", In: openapi.InQuery, Description: "Defaults to temperature.", Schema: openapi.String("", readingNames...)},
+			}, timeRangeParams()...), unitParams()...),
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("Statistics of each window with measurements.", stats),
+				"400": textResponse("Invalid query parameters."),
+				"404": {Description: "No measurements of the city."},
+			},
+		},
+		"GET /cities/{name}/stream": {
+			Summary: "Streams new measurements of a city.",
+			Description: "Server-sent events whose data is a measurement as JSON. Events carry increasing IDs; " +
+				"reconnecting clients receive missed events of a bounded buffer by sending the last ID. " +
+				"If the client does not keep up, events are dropped and announced by \"gap\" events " +
+				"whose data is a `StreamGap`. Idle streams receive heartbeat comments.",
+			Tags: []string{"cities"},
+			Parameters: append([]openapi.Parameter{
+				cityParam(),
+				lastEventIDParam(),
+				overflowParam(),
+			}, unitParams()...),
+			Responses: map[string]openapi.Response{
+				"200": {Description: "Event stream.", Content: map[string]openapi.MediaType{"text/event-stream": {Schema: openapi.String("")}}},
+				"400": textResponse("Invalid query parameters."),
+			},
+		},
+		"GET /stream": {
+			Summary: "Streams new measurements of several cities.",
+			Description: "Like `/cities/{name}/stream`, but the data of each event is a `StreamEvent` " +
+				"which carries the city with the measurement.",
+			Tags: []string{"cities"},
+			Parameters: append([]openapi.Parameter{
+				{Name: "cities", In: openapi.InQuery, Required: true, Description: "Comma-separated cities, or \"*\" for all cities.", Schema: openapi.String("")},
+				lastEventIDParam(),
+				overflowParam(),
+			}, unitParams()...),
+			Responses: map[string]openapi.Response{
+				"200": {Description: "Event stream.", Content: map[string]openapi.MediaType{"text/event-stream": {Schema: openapi.String("")}}},
+				"400": textResponse("Invalid query parameters."),
+			},
+		},
+		"GET /cities/{name}/ws": {
+			Summary: "Streams new measurements over a WebSocket.",
+			Description: "The connection starts subscribed to the city of the path. Clients send `WSRequest` " +
+				"messages to subscribe to or unsubscribe from cities; the server sends `WSEvent` messages " +
+				"with measurements, gaps of dropped measurements, the current subscriptions after each " +
+				"request, and errors. Subscriptions end with an error if the client is disconnected.",
+			Tags:       []string{"cities"},
+			Parameters: append([]openapi.Parameter{cityParam(), overflowParam()}, unitParams()...),
+			Responses: map[string]openapi.Response{
+				"101": {Description: "Switched to the WebSocket protocol."},
+				"400": textResponse("Invalid query parameters or handshake."),
+				"426": textResponse("The request is not a WebSocket handshake."),
+			},
+		},
+		"POST /admin/compact": {
+			Summary: "Compacts the write-ahead log into a snapshot.",
+			Tags:    []string{"admin"},
+			Responses: map[string]openapi.Response{
+				"200": {Description: "The storage was compacted."},
+				"409": errorResponse("Measurements are not persisted."),
+				"500": errorResponse("Compacting failed."),
+			},
+		},
+		"GET /admin/ratelimits": {
+			Summary: "Reports the requests rejected by each rate limit.",
+			Tags:    []string{"admin"},
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("Statistics of all rate limits.", openapi.SchemaFor[[]RateLimitStats](gen)),
+			},
+		},
+		"GET /admin/subscribers": {
+			Summary:     "Reports the current subscribers of event streams and WebSockets.",
+			Description: "Subscribers of several cities are listed under the city \"*\".",
+			Tags:        []string{"admin"},
+			Responses: map[string]openapi.Response{
+				"200": jsonResponse("Subscribers by city, oldest first.", openapi.SchemaFor[[]CitySubscribers](gen)),
+				"503": errorResponse("The streamer is not responding."),
+			},
+		},
+	}
+}
+
+func cityParam() openapi.Parameter {
+	return openapi.Parameter{Name: "name", In: openapi.InPath, Required: true, Schema: openapi.String("")}
+}
+
+func lastEventIDParam() openapi.Parameter {
+	return openapi.Parameter{Name: "Last-Event-ID", In: openapi.InHeader, Description: "ID of the last received event.", Schema: openapi.String("")}
+}
+
+func overflowParam() openapi.Parameter {
+	return openapi.Parameter{
+		Name: "overflow", In: openapi.InQuery,
+		Description: "What happens to measurements if the client does not keep up. Defaults to the configured policy; " +
+			"`disconnect` drops measurements until the configured maximum is reached.",
+		Schema: openapi.String("", config.OverflowDropNewest, config.OverflowDropOldest, config.OverflowDisconnect),
+	}
+}
+
+func unitParams() []openapi.Parameter {
+	systems := slices.Sorted(maps.Keys(unitSystems))
+	enum := make([]any, len(systems))
+	for i, system := range systems {
+		enum[i] = string(system)
+	}
+
+	return []openapi.Parameter{
+		{Name: "units", In: openapi.InQuery, Description: "Unit system of returned readings. Defaults to metric.", Schema: openapi.String("", enum...)},
+		{Name: "Accept-Units", In: openapi.InHeader, Description: "Unit system if `units` is not set.", Schema: openapi.String("", enum...)},
+	}
+}
+
+func languageParam() openapi.Parameter {
+	return openapi.Parameter{
+		Name: "Accept-Language", In: openapi.InHeader,
+		Description: "Language whose decimal separator is used in text responses.", Schema: openapi.String(""),
+	}
+}
+
+func timeRangeParams() []openapi.Parameter {
+	return []openapi.Parameter{
+		{Name: "from", In: openapi.InQuery, Description: "Start of the range (inclusive).", Schema: openapi.String("date-time")},
+		{Name: "to", In: openapi.InQuery, Description: "End of the range (inclusive).", Schema: openapi.String("date-time")},
+	}
+}
+
+func jsonResponse(desc string, schema *openapi.Schema) openapi.Response {
+	return openapi.Response{
+		Description: desc,
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func textResponse(desc string) openapi.Response {
+	return openapi.Response{
+		Description: desc,
+		Content:     map[string]openapi.MediaType{"text/plain": {Schema: openapi.String("")}},
+	}
+}
+
+func busyResponse() openapi.Response {
+	return retryResponse(errorResponse("Too many measurements are waiting for the streamer."))
+}
+
+// retryResponse adds the `Retry-After` header set if the streamer is busy.
+func retryResponse(resp openapi.Response) openapi.Response {
+	resp.Headers = map[string]openapi.Header{
+		"Retry-After": {Description: "Seconds until measurements should be submitted again.", Schema: &openapi.Schema{Type: "integer"}},
+	}
+	return resp
+}
+
+func errorResponse(desc string) openapi.Response {
+	return openapi.Response{
+		Description: desc,
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: openapi.Ref("ErrorResponse")}},
+	}
+}
+
+// validateRequest rejects requests which do not match the operation.
+func validateRequest(doc *openapi.Document, op *openapi.Operation, next http.HandlerFunc) http.HandlerFunc {
+	// Operations without a body are not expected to send more than a few
+	// bytes, so their requests are not buffered up to the batch size.
+	limit := int64(maxBatchSize)
+	if op.RequestBody == nil {
+		limit = maxUndocumentedBodySize
+	}
+
+	// Only the parameters of batch uploads are validated here.
+	checked := op
+	if validatesItems(op) {
+		checked = &openapi.Operation{Parameters: op.Parameters}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, ok := readBody(w, r, limit)
+		if !ok {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		valErrs := doc.ValidateRequest(checked, r, body)
+		if len(valErrs) > 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{"request does not match the API specification", fieldErrors(valErrs)})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// validatesItems reports whether the body of the operation is a batch upload,
+// whose items are validated by the handler.
+func validatesItems(op *openapi.Operation) bool {
+	if op.RequestBody == nil {
+		return false
+	}
+
+	schema := op.RequestBody.Content["application/x-ndjson"].Schema
+	return schema != nil && schema.Ref == openapi.Ref(batchItemSchema).Ref
+}
+
+func fieldErrors(valErrs []openapi.ValidationError) []FieldError {
+	fieldErrs := make([]FieldError, len(valErrs))
+	for i, valErr := range valErrs {
+		fieldErrs[i] = FieldError(valErr)
+	}
+	return fieldErrs
+}
+
+func serveDocument(doc *openapi.Document) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, doc)
+	}
+}
+
+func getDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(docsPage)
+}
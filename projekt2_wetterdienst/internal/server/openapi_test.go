@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/openapi"
+	"weather-service/internal/services"
+)
+
+// Ensures that all routes are documented and that the document accepts both
+// measurement formats for submission but rejects unknown fields.
+func TestAPISpec(t *testing.T) {
+	t.Parallel()
+
+	svr := &Server{Broker: NewStreamer(), Services: &services.Registry{}}
+	require.NoError(t, svr.Init(context.Background()))
+
+	rec := httptest.NewRecorder()
+	svr.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Contains(t, doc.Paths, "/cities/{name}/stream")
+	require.Contains(t, doc.Components.Schemas, "TempMessage")
+	require.Contains(t, doc.Components.Schemas, "ErrorResponse")
+
+	spec := newAPISpec()
+	op, ok := spec.addRoute("POST /cities/{name}", rolePublic, false)
+	require.True(t, ok)
+	spec.document()
+
+	validate := func(body string) []openapi.ValidationError {
+		r := httptest.NewRequest(http.MethodPost, "/cities/Berlin", strings.NewReader(body))
+		r.SetPathValue("name", "Berlin")
+		return spec.doc.ValidateRequest(op, r, []byte(body))
+	}
+
+	require.Empty(t, validate(`{"version": 2, "time": "2025-10-01T12:00:00Z", "temperature": {"value": 20, "unit": "F"}}`))
+	require.Empty(t, validate(`{"time": "2025-10-01T12:00:00Z", "humidity": {"value": 50, "unit": "%"}}`))
+	require.Empty(t, validate(`{"temp": 20, "time": "2025-10-01T12:00:00Z"}`))
+	require.NotEmpty(t, validate(`{"temp": 20, "time": "2025-10-01T12:00:00Z", "humidity": {"value": 50, "unit": "%"}}`))
+	require.NotEmpty(t, validate(`{"time": "2025-10-01T12:00:00Z", "temperature": {"value": 20, "unit": "parsec"}}`))
+}
+
+// Ensures that only bodies exceeding the limit of the operation are answered
+// with 413, and that operations without a body have a small limit.
+func TestValidateRequestBody(t *testing.T) {
+	t.Parallel()
+
+	spec := newAPISpec()
+	postOp, ok := spec.addRoute("POST /cities/{name}", rolePublic, false)
+	require.True(t, ok)
+	getOp, ok := spec.addRoute("GET /cities", rolePublic, false)
+	require.True(t, ok)
+	spec.document()
+
+	handler := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	serve := func(op *openapi.Operation, r *http.Request) int {
+		r.SetPathValue("name", "Berlin")
+		rec := httptest.NewRecorder()
+		validateRequest(spec.doc, op, handler)(rec, r)
+		return rec.Code
+	}
+
+	body := `{"temp": 20, "time": "2025-10-01T12:00:00Z"}` + strings.Repeat(" ", 2*maxUndocumentedBodySize)
+	require.Equal(t, http.StatusOK, serve(postOp, httptest.NewRequest(http.MethodPost, "/cities/Berlin", strings.NewReader(body))))
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve(getOp, httptest.NewRequest(http.MethodGet, "/cities", strings.NewReader(body))))
+	require.Equal(t, http.StatusOK, serve(getOp, httptest.NewRequest(http.MethodGet, "/cities", nil)))
+
+	body = strings.Repeat(" ", maxBatchSize+1)
+	require.Equal(t, http.StatusRequestEntityTooLarge, serve(postOp, httptest.NewRequest(http.MethodPost, "/cities/Berlin", strings.NewReader(body))))
+
+	r := httptest.NewRequest(http.MethodPost, "/cities/Berlin", iotest.ErrReader(errors.New("connection reset")))
+	require.Equal(t, http.StatusBadRequest, serve(postOp, r))
+}
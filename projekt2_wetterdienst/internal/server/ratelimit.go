@@ -0,0 +1,207 @@
+package server
+
+import (
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"weather-service/internal/config"
+)
+
+// Buckets unused for this long are removed.
+const bucketIdleTimeout = 10 * time.Minute
+
+// rateLimiter implements a token bucket per client.
+type rateLimiter struct {
+	route string
+	rate  float64
+	burst float64
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	// Number of rejected requests in total and per client. Clients are
+	// removed along with their buckets, so only recent ones are listed.
+	total     uint64
+	throttled map[string]uint64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		route:     cfg.Route,
+		rate:      cfg.Rate,
+		burst:     float64(cfg.Burst),
+		buckets:   map[string]*bucket{},
+		throttled: map[string]uint64{},
+	}
+}
+
+// allow takes a token from the client's bucket. If it is empty, the time
+// until the next token is available is returned.
+func (rl *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	bkt := rl.refill(key, now)
+	if bkt.tokens >= 1 {
+		bkt.tokens--
+		return true, 0
+	}
+
+	rl.total++
+	rl.throttled[key]++
+	return false, rl.wait(bkt)
+}
+
+// check is like `allow()` but does not take a token.
+func (rl *rateLimiter) check(key string, now time.Time) (bool, time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	bkt := rl.refill(key, now)
+	if bkt.tokens >= 1 {
+		return true, 0
+	}
+
+	rl.total++
+	rl.throttled[key]++
+	return false, rl.wait(bkt)
+}
+
+// spend takes a token from the client's bucket if there is one, without
+// rejecting the request.
+func (rl *rateLimiter) spend(key string, now time.Time) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	bkt := rl.refill(key, now)
+	bkt.tokens = max(0, bkt.tokens-1)
+}
+
+// refill returns the client's bucket with the tokens added since its last
+// use.
+func (rl *rateLimiter) refill(key string, now time.Time) *bucket {
+	if now.Sub(rl.lastSweep) > bucketIdleTimeout {
+		rl.sweep(now)
+	}
+
+	bkt, ok := rl.buckets[key]
+	if !ok {
+		bkt = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bkt
+	}
+
+	elapsed := now.Sub(bkt.last).Seconds()
+	bkt.tokens = min(rl.burst, bkt.tokens+elapsed*rl.rate)
+	bkt.last = now
+
+	return bkt
+}
+
+// wait returns the time until the next token of the bucket is available.
+func (rl *rateLimiter) wait(bkt *bucket) time.Duration {
+	return time.Duration((1 - bkt.tokens) / rl.rate * float64(time.Second))
+}
+
+// sweep removes buckets which have not been used for a while and the
+// rejections of their clients.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for key, bkt := range rl.buckets {
+		if now.Sub(bkt.last) > bucketIdleTimeout {
+			delete(rl.buckets, key)
+			delete(rl.throttled, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// RateLimitStats reports how many requests to a route were rejected, in total
+// and by the clients which were active recently.
+type RateLimitStats struct {
+	Route     string            `json:"route"`
+	Rate      float64           `json:"rate"`
+	Burst     int               `json:"burst"`
+	Throttled uint64            `json:"throttled"`
+	Clients   map[string]uint64 `json:"clients"`
+}
+
+func (rl *rateLimiter) stats() RateLimitStats {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	stats := RateLimitStats{
+		Route:     rl.route,
+		Rate:      rl.rate,
+		Burst:     int(rl.burst),
+		Throttled: rl.total,
+		Clients:   maps.Clone(rl.throttled),
+	}
+
+	return stats
+}
+
+// limit rejects requests with 429 if the client exceeds the limit. Clients
+// are identified by their principal or, if not authenticated, by their IP.
+func (rl *rateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := rl.allow(clientKey(r), time.Now())
+		if !ok {
+			rl.reject(w, wait)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// limitFailures rejects requests with 429 if their IP failed to authenticate
+// too often. It wraps `authorize()`, so floods of invalid credentials are
+// rejected before they are verified or their bodies are read.
+func (rl *rateLimiter) limitFailures(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "failed:" + ipKey(r)
+
+		ok, wait := rl.check(key, time.Now())
+		if !ok {
+			rl.reject(w, wait)
+			return
+		}
+
+		rr := &responseRecorder{ResponseWriter: w}
+		next(rr, r)
+
+		if rr.statusCode() == http.StatusUnauthorized {
+			rl.spend(key, time.Now())
+		}
+	}
+}
+
+func (rl *rateLimiter) reject(w http.ResponseWriter, wait time.Duration) {
+	rateLimited.Inc(rl.route)
+
+	setRetryAfter(w, wait)
+	writeJSON(w, http.StatusTooManyRequests, ErrorResponse{Error: "rate limit exceeded"})
+}
+
+func clientKey(r *http.Request) string {
+	if prin, ok := r.Context().Value(principalKey).(principal); ok {
+		return prin.role + ":" + prin.name
+	}
+	return ipKey(r)
+}
+
+func ipKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
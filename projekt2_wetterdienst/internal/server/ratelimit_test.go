@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/config"
+)
+
+// Ensures that bursts are limited, tokens are refilled over time, and that
+// clients do not affect each other. Idle clients are removed.
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	rl := newRateLimiter(config.RateLimitConfig{Route: "POST /x", Rate: 2, Burst: 3})
+	now := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+
+	for range 3 {
+		ok, _ := rl.allow("a", now)
+		require.True(t, ok)
+	}
+
+	ok, wait := rl.allow("a", now)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, wait)
+
+	// Another client has its own bucket.
+	ok, _ = rl.allow("b", now)
+	require.True(t, ok)
+
+	// One token is refilled after half a second.
+	ok, _ = rl.allow("a", now.Add(500*time.Millisecond))
+	require.True(t, ok)
+	ok, _ = rl.allow("a", now.Add(500*time.Millisecond))
+	require.False(t, ok)
+
+	stats := rl.stats()
+	require.Equal(t, uint64(2), stats.Throttled)
+	require.Equal(t, map[string]uint64{"a": 2}, stats.Clients)
+
+	// Idle clients are removed, but their rejections are still counted.
+	later := now.Add(2 * bucketIdleTimeout)
+	ok, _ = rl.allow("b", later)
+	require.True(t, ok)
+
+	stats = rl.stats()
+	require.Equal(t, uint64(2), stats.Throttled)
+	require.Empty(t, stats.Clients)
+	require.Len(t, rl.buckets, 1)
+}
+
+// Ensures that IPs which fail to authenticate too often are rejected before
+// their credentials are checked, while valid requests do not count as
+// failures.
+func TestRateLimiterFailures(t *testing.T) {
+	authCfg := config.C.Auth
+	config.C.Auth = config.AuthConfig{
+		Tokens: []config.TokenConfig{{Name: "reader", Token: "read-token", Role: config.RoleRead}},
+	}
+	t.Cleanup(func() { config.C.Auth = authCfg })
+
+	rl := newRateLimiter(config.RateLimitConfig{Route: "GET /x", Rate: 0.001, Burst: 2})
+	handler := rl.limitFailures(authorize(config.RoleRead, rl.limit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	get := func(remoteAddr, token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/x", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, get("192.0.2.1:1000", "read-token"))
+	require.Equal(t, http.StatusOK, get("192.0.2.1:1000", "read-token"))
+	require.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1000", "wrong"))
+	require.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1001", "wrong"))
+	require.Equal(t, http.StatusTooManyRequests, get("192.0.2.1:1002", "wrong"))
+
+	// Other IPs are not affected.
+	require.Equal(t, http.StatusUnauthorized, get("192.0.2.2:1000", "wrong"))
+
+	stats := rl.stats()
+	require.Equal(t, map[string]uint64{"failed:ip:192.0.2.1": 1}, stats.Clients)
+}
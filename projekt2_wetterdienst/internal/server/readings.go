@@ -0,0 +1,63 @@
+package server
+
+import (
+	"weather-service/internal/config"
+)
+
+// reading describes one kind of value a measurement can report.
+type reading struct {
+	// Name as used in JSON and query parameters.
+	name string
+
+	// Unit in which values are stored.
+	unit Unit
+
+	// Returns the corresponding field of a measurement.
+	field func(m *Measurement) **Quantity
+
+	// Returns the range of plausible values.
+	limits func(cfg *config.ValidationConfig) config.Range
+}
+
+var readings = []reading{
+	{
+		"temperature", UnitCelsius,
+		func(m *Measurement) **Quantity { return &m.Temperature },
+		func(cfg *config.ValidationConfig) config.Range { return cfg.Temperature },
+	},
+	{
+		"humidity", UnitPercent,
+		func(m *Measurement) **Quantity { return &m.Humidity },
+		func(cfg *config.ValidationConfig) config.Range { return cfg.Humidity },
+	},
+	{
+		"pressure", UnitHectopascal,
+		func(m *Measurement) **Quantity { return &m.Pressure },
+		func(cfg *config.ValidationConfig) config.Range { return cfg.Pressure },
+	},
+	{
+		"windSpeed", UnitMetersPerSecond,
+		func(m *Measurement) **Quantity { return &m.WindSpeed },
+		func(cfg *config.ValidationConfig) config.Range { return cfg.WindSpeed },
+	},
+	{
+		"windDirection", UnitDegree,
+		func(m *Measurement) **Quantity { return &m.WindDirection },
+		func(cfg *config.ValidationConfig) config.Range { return cfg.WindDirection },
+	},
+	{
+		"precipitation", UnitMillimeter,
+		func(m *Measurement) **Quantity { return &m.Precipitation },
+		func(cfg *config.ValidationConfig) config.Range { return cfg.Precipitation },
+	},
+}
+
+// findReading returns the reading with the given name.
+func findReading(name string) (reading, bool) {
+	for _, rd := range readings {
+		if rd.name == name {
+			return rd, true
+		}
+	}
+	return reading{}, false
+}
@@ -3,47 +3,174 @@ package server
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/risingwavelabs/eris"
 
 	"weather-service/internal/config"
+	"weather-service/internal/logging"
+	"weather-service/internal/metrics"
+	"weather-service/internal/openapi"
+	"weather-service/internal/services"
 )
 
+var logger = logging.For("server")
+
 type Server struct {
+	// Distributes measurements to subscribers of streams. Required.
+	Broker Broker
+
+	// Statuses of the services, reported by `/readyz`. Required.
+	Services *services.Registry
+
 	server *http.Server
+
+	// Measurements of all cities.
+	store Store
+
+	// Set if measurements are persisted. Also used as `store` then.
+	wal *walStore
+
+	// Set if requests are validated against the API document. Used for the
+	// items of batch uploads, which are validated one by one.
+	apiDoc *openapi.Document
+
+	// Rate limiters by route.
+	limiters map[string]*rateLimiter
+
+	// Set if HTTPS is used.
+	tls *tlsReloader
+
+	// Open WebSocket connections, which are not tracked by `server`.
+	sockets sync.WaitGroup
 }
 
-func (Server) Name() string { return "API Server" }
+func (*Server) Name() string { return "API Server" }
 
 func (svr *Server) Init(ctx context.Context) error {
+	if svr.Broker == nil {
+		return eris.New("no broker for streams")
+	} else if svr.Services == nil {
+		return eris.New("no registry of services")
+	}
+
+	svr.store = NewMemoryStore()
+	if len(config.C.Storage.Dir) > 0 {
+		wal, err := openWALStore(config.C.Storage.Dir)
+		if err != nil {
+			return eris.Wrap(err, "failed to open storage")
+		}
+		svr.wal = wal
+		svr.store = wal
+	}
+
+	if config.C.TLS.Enabled() {
+		reloader, err := newTLSReloader(config.C.TLS)
+		if err != nil {
+			return eris.Wrap(err, "failed to load TLS files")
+		}
+		svr.tls = reloader
+	}
+
+	svr.limiters = map[string]*rateLimiter{}
+	for _, limitCfg := range config.C.RateLimits {
+		svr.limiters[limitCfg.Route] = newRateLimiter(limitCfg)
+	}
+
 	router := http.NewServeMux()
+	routes := map[string]bool{}
+	spec := newAPISpec()
+	undocumented := []string{}
+	if config.C.OpenAPI.ValidateRequests {
+		svr.apiDoc = spec.doc
+	}
 
-	router.HandleFunc("GET /", get)
-	router.HandleFunc("GET /cities/{name}", getCitiesName)
-	router.HandleFunc("POST /cities/{name}", postCitiesName)
-	router.HandleFunc("GET /cities/{name}/stream", getCitiesNameStream)
+	handle := func(pattern, role string, handler http.HandlerFunc) {
+		limiter, limited := svr.limiters[pattern]
+
+		op, ok := spec.addRoute(pattern, role, limited)
+		if !ok {
+			undocumented = append(undocumented, pattern)
+		} else if config.C.OpenAPI.ValidateRequests {
+			handler = validateRequest(spec.doc, op, handler)
+		}
+
+		if limited {
+			handler = limiter.limitFailures(authorize(role, limiter.limit(handler)))
+		} else {
+			handler = authorize(role, handler)
+		}
+		router.HandleFunc(pattern, handler)
+		routes[pattern] = true
+	}
+
+	handle("GET /", rolePublic, get)
+	handle("GET /healthz", rolePublic, getHealthz)
+	handle("GET /readyz", rolePublic, svr.getReadyz)
+	handle("GET /cities", config.RoleRead, svr.getCities)
+	handle("GET /cities/{name}", config.RoleRead, svr.getCitiesName)
+	handle("POST /cities/{name}", config.RoleStation, svr.postCitiesName)
+	handle("GET /cities/{name}/history", config.RoleRead, svr.getCitiesNameHistory)
+	handle("GET /cities/{name}/aggregate", config.RoleRead, svr.getCitiesNameAggregate)
+	handle("GET /cities/{name}/stream", config.RoleRead, svr.getCitiesNameStream)
+	handle("GET /cities/{name}/ws", config.RoleRead, svr.getCitiesNameWS)
+	handle("GET /stream", config.RoleRead, svr.getStream)
+	handle("POST /measurements", config.RoleStation, svr.postMeasurements)
+	handle("POST /admin/compact", config.RoleAdmin, svr.postAdminCompact)
+	handle("GET /admin/ratelimits", config.RoleAdmin, svr.getAdminRateLimits)
+	handle("GET /admin/subscribers", config.RoleAdmin, svr.getAdminSubscribers)
+
+	// Public so scrapers do not need credentials.
+	handle("GET /metrics", rolePublic, metrics.Default.Handler().ServeHTTP)
+
+	handle("GET /openapi.json", rolePublic, serveDocument(spec.document()))
+	handle("GET /docs", rolePublic, getDocs)
+
+	if len(undocumented) > 0 {
+		return eris.Errorf("routes without API documentation: %v", undocumented)
+	}
+
+	for route := range svr.limiters {
+		if !routes[route] {
+			return eris.Errorf("rate limit for unknown route '%s'", route)
+		}
+	}
 
 	svr.server = &http.Server{
 		Addr:    ":" + strconv.Itoa(int(config.C.APIPort)),
-		Handler: router,
+		Handler: chain(router, requestID, accessLog, instrument, recovery),
+	}
+	if svr.tls != nil {
+		svr.server.TLSConfig = svr.tls.tlsConfig()
 	}
 
 	return nil
 }
 
 func (svr *Server) Run(ctx context.Context) error {
-	fmt.Printf("%s is listening on port %s\n", svr.Name(), svr.server.Addr)
+	logger.Info("listening", "service", svr.Name(), "addr", svr.server.Addr, "tls", svr.tls != nil)
 
 	svr.server.BaseContext = func(_ net.Listener) context.Context {
 		return ctx
 	}
 
-	err := svr.server.ListenAndServe()
+	if svr.wal != nil {
+		go svr.compactLoop(ctx)
+	}
+
+	var err error
+	if svr.tls != nil {
+		go svr.tls.watch(ctx)
+
+		// Certificates are provided by the TLS config.
+		err = svr.server.ListenAndServeTLS("", "")
+	} else {
+		err = svr.server.ListenAndServe()
+	}
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return eris.Wrapf(err, "%s stopped", svr.Name())
 	}
@@ -60,5 +187,50 @@ func (svr *Server) Stop() error {
 		return eris.Wrapf(err, "failed to shut down %s", svr.Name())
 	}
 
+	// WebSockets close themselves once the context of `Run()` is done.
+	closed := make(chan struct{})
+	go func() {
+		svr.sockets.Wait()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		logger.Warn("websockets did not close in time")
+	}
+
+	err = svr.store.Close()
+	if err != nil {
+		return eris.Wrap(err, "failed to close storage")
+	}
+
 	return nil
 }
+
+// Check ensures that measurements can still be stored.
+func (svr *Server) Check(_ context.Context) error {
+	if svr.wal == nil {
+		return nil
+	}
+	return svr.wal.Writable()
+}
+
+// compactLoop periodically compacts the write-ahead log until `ctx` is done.
+func (svr *Server) compactLoop(ctx context.Context) {
+	ticker := time.NewTicker(config.C.Storage.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := svr.wal.Compact()
+		if err != nil {
+			logger.Error("failed to compact storage", "error", err)
+		}
+	}
+}
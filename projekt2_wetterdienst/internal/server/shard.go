@@ -0,0 +1,276 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/risingwavelabs/eris"
+
+	"weather-service/internal/config"
+)
+
+// shard distributes the events of the topics assigned to it. Each shard runs
+// in its own goroutine, so a busy city only delays the cities of its shard.
+type shard struct {
+	eventChan  chan Event
+	listChan   chan *subscription
+	unlistChan chan *subscription
+	infoChan   chan chan []CitySubscribers
+	pingChan   chan struct{}
+
+	// Subscriptions by topic. Only used by `run()`.
+	listeners map[string][]*subscription
+
+	// Recent events of each city for listeners which resume a stream. The
+	// shard of `AllCities` keeps those of all cities.
+	history map[string]*eventRing
+}
+
+func newShard() *shard {
+	return &shard{
+		eventChan:  make(chan Event, config.C.Stream.PostQueue),
+		listChan:   make(chan *subscription, 256),
+		unlistChan: make(chan *subscription, 256),
+		infoChan:   make(chan chan []CitySubscribers),
+		pingChan:   make(chan struct{}),
+		listeners:  map[string][]*subscription{},
+		history:    map[string]*eventRing{},
+	}
+}
+
+func (sh *shard) run(ctx context.Context) {
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+			continue
+
+		case event := <-sh.eventChan:
+			sh.handle(event)
+
+		case <-sh.pingChan:
+
+		case reply := <-sh.infoChan:
+			reply <- sh.subscribers()
+
+		case sub := <-sh.unlistChan:
+			sh.remove(sub)
+
+		case sub := <-sh.listChan:
+			sh.add(sub)
+		}
+	}
+
+	sh.close()
+}
+
+// post passes the event to `run()`. While the queue is full, it waits, so a
+// busy shard slows down `Streamer.Run()` and, in turn, `Streamer.Post()`
+// rejects measurements with `ErrBrokerBusy`. Events are only dropped by the
+// overflow policies of the subscriptions. It fails once `ctx` is done.
+func (sh *shard) post(ctx context.Context, event Event) error {
+	select {
+	case sh.eventChan <- event:
+		return nil
+	case <-ctx.Done():
+		return ErrBrokerBusy
+	}
+}
+
+func (sh *shard) handle(event Event) {
+	sh.record(event)
+
+	sh.deliver(event.City, event)
+	sh.deliver(AllCities, event)
+}
+
+func (sh *shard) add(sub *subscription) {
+	// The removal might have been handled before.
+	if sub.ctx.Err() != nil {
+		sub.stop()
+		close(sub.msgChan)
+		return
+	}
+
+	// Missed events are replayed before any new ones, so none are lost or
+	// duplicated.
+	if sub.after > 0 {
+		for _, event := range sh.replay(sub) {
+			sub.msgChan <- event
+		}
+	}
+
+	sh.listeners[sub.topic] = append(sh.listeners[sub.topic], sub)
+	streamLogger.Debug("added listener", "city", sub.topic)
+	streamSubscribers.Inc(sub.topic)
+}
+
+func (sh *shard) listen(ctx context.Context, topic string, cities map[string]bool, opts SubscribeOptions) *subscription {
+	if len(opts.Overflow) == 0 {
+		opts.Overflow = config.C.Stream.Overflow
+	}
+
+	sub := &subscription{
+		ctx: ctx,
+		// Replayed events of a single city fit into the channel.
+		msgChan:    make(chan Event, max(256, config.C.Stream.ReplayBuffer)),
+		topic:      topic,
+		after:      opts.After,
+		overflow:   opts.Overflow,
+		remoteAddr: opts.RemoteAddr,
+		connected:  time.Now(),
+		cities:     cities,
+	}
+
+	// Subscriptions are removed as soon as their context is done, even if no
+	// more events are posted for their cities.
+	sub.stop = context.AfterFunc(ctx, func() {
+		for {
+			select {
+			case sh.unlistChan <- sub:
+				return
+
+			// The events are of no use anymore. The channel is closed once
+			// the shard removed the subscription.
+			case _, ok := <-sub.msgChan:
+				if !ok {
+					return
+				}
+			}
+		}
+	})
+	sh.listChan <- sub
+
+	return sub
+}
+
+// close ends all subscriptions once the shard stops.
+func (sh *shard) close() {
+	for city, listenerList := range sh.listeners {
+		for _, sub := range listenerList {
+			sub.stop()
+			close(sub.msgChan)
+		}
+		delete(sh.listeners, city)
+		streamSubscribers.Delete(city)
+	}
+	clear(sh.history)
+}
+
+func (sh *shard) check(ctx context.Context) error {
+	select {
+	case sh.pingChan <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return eris.New("streamer is not responding")
+	}
+}
+
+// deliver sends the event to the subscriptions of the topic and removes
+// those whose context is done or which must be disconnected.
+func (sh *shard) deliver(topic string, event Event) {
+	var ended []*subscription
+	for _, sub := range sh.listeners[topic] {
+		switch {
+		case sub.ctx.Err() != nil:
+			ended = append(ended, sub)
+
+		case sub.wants(event.City) && !sub.send(event):
+			streamLogger.Warn("disconnected slow subscriber", "city", event.City, "dropped", sub.Dropped())
+			streamDisconnects.Inc(event.City)
+			ended = append(ended, sub)
+		}
+	}
+
+	for _, sub := range ended {
+		sh.remove(sub)
+	}
+}
+
+// remove closes the subscription unless it was removed before.
+func (sh *shard) remove(sub *subscription) {
+	listList := sh.listeners[sub.topic]
+	idx := slices.Index(listList, sub)
+	if idx < 0 {
+		return
+	}
+
+	sub.stop()
+	close(sub.msgChan)
+
+	// Remove by swapping with last.
+	lastIdx := len(listList) - 1
+	listList[idx] = listList[lastIdx]
+	listList[lastIdx] = nil
+	listList = listList[:lastIdx]
+	streamLogger.Debug("removed listener", "city", sub.topic)
+
+	if len(listList) == 0 {
+		// Cities are taken from requests, so series of cities
+		// without listeners are removed.
+		delete(sh.listeners, sub.topic)
+		streamSubscribers.Delete(sub.topic)
+	} else {
+		sh.listeners[sub.topic] = listList
+		streamSubscribers.Dec(sub.topic)
+	}
+}
+
+// replay returns the buffered events of the new subscription which are newer
+// than `sub.after`, oldest first. Only the newest ones fit into its channel.
+func (sh *shard) replay(sub *subscription) []Event {
+	var events []Event
+	if sub.topic == AllCities {
+		for city, ring := range sh.history {
+			if sub.wants(city) {
+				events = append(events, ring.after(sub.after)...)
+			}
+		}
+		slices.SortFunc(events, func(a, b Event) int { return cmp.Compare(a.ID, b.ID) })
+	} else if ring, ok := sh.history[sub.topic]; ok {
+		events = ring.after(sub.after)
+	}
+
+	return events[max(0, len(events)-cap(sub.msgChan)):]
+}
+
+func (sh *shard) record(event Event) {
+	size := config.C.Stream.ReplayBuffer
+	if size <= 0 {
+		return
+	}
+
+	ring, ok := sh.history[event.City]
+	if !ok {
+		ring = &eventRing{events: make([]Event, 0, size)}
+		sh.history[event.City] = ring
+	}
+	ring.add(event)
+}
+
+func (sh *shard) subscribers() []CitySubscribers {
+	allSubs := make([]CitySubscribers, 0, len(sh.listeners))
+	for topic, listList := range sh.listeners {
+		citySubs := CitySubscribers{City: topic}
+		for _, sub := range listList {
+			citySubs.Subscribers = append(citySubs.Subscribers, SubscriberInfo{
+				Cities:     slices.Sorted(maps.Keys(sub.cities)),
+				RemoteAddr: sub.remoteAddr,
+				Connected:  sub.connected,
+				Overflow:   sub.overflow,
+				Buffered:   len(sub.msgChan),
+				Dropped:    sub.Dropped(),
+			})
+		}
+
+		slices.SortFunc(citySubs.Subscribers, func(a, b SubscriberInfo) int {
+			return a.Connected.Compare(b.Connected)
+		})
+		allSubs = append(allSubs, citySubs)
+	}
+
+	return allSubs
+}
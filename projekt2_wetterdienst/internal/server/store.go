@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate is returned by `Store.Add()` if the city already has the same
+// measurement, e.g., as the submission was retried.
+var ErrDuplicate = errors.New("measurement of this time is already stored")
+
+// ErrConflict is returned by `Store.Add()` if the city already has a different
+// measurement with the same time.
+var ErrConflict = errors.New("a different measurement of this time is already stored")
+
+// Store keeps all measurements reported for each city.
+type Store interface {
+	// Add stores a new measurement of the given city. The measurement is
+	// ignored with `ErrDuplicate` if it is stored already, or with
+	// `ErrConflict` if a different one of the same time is.
+	Add(city string, msg Measurement) error
+
+	// Cities returns the names of all cities with measurements.
+	Cities() []string
+
+	// Latest returns the measurement of the given city with the most recent
+	// time. The second result is false if the city has no measurements.
+	Latest(city string) (Measurement, bool)
+
+	// History returns the measurements of the given city with
+	// `from <= Time < to`, ordered by time. A zero `from` or `to` leaves the
+	// respective side unbounded. The second result is false if the city has
+	// no measurements at all.
+	History(city string, from, to time.Time) ([]Measurement, bool)
+
+	// Close releases all resources held by the store.
+	Close() error
+}
+
+// memoryStore is an in-process time-series store. Measurements of each city
+// are kept in a slice sorted by time.
+type memoryStore struct {
+	mutex  sync.RWMutex
+	series map[string][]Measurement
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		series: map[string][]Measurement{},
+	}
+}
+
+func (ms *memoryStore) Add(city string, msg Measurement) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	idx, err := ms.check(city, msg)
+	if err != nil {
+		return err
+	}
+	ms.series[city] = slices.Insert(ms.series[city], idx, msg)
+
+	return nil
+}
+
+// check returns the index at which the measurement belongs, or an error if
+// one of the same time is stored already. The caller must hold the mutex.
+func (ms *memoryStore) check(city string, msg Measurement) (int, error) {
+	idx, found := ms.find(city, msg.Time)
+	if !found {
+		return idx, nil
+	} else if ms.series[city][idx-1].Equal(msg) {
+		return idx, ErrDuplicate
+	}
+	return idx, ErrConflict
+}
+
+// find returns the index at which a measurement of the given time belongs and
+// whether one of that time exists. The caller must hold the mutex.
+func (ms *memoryStore) find(city string, ts time.Time) (int, bool) {
+	series := ms.series[city]
+
+	// Measurements usually arrive in order, hence, appending is the common
+	// case. Late ones are inserted after all measurements with the same time.
+	idx := len(series)
+	for idx > 0 && series[idx-1].Time.After(ts) {
+		idx--
+	}
+
+	return idx, idx > 0 && series[idx-1].Time.Equal(ts)
+}
+
+func (ms *memoryStore) Cities() []string {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	return slices.Collect(maps.Keys(ms.series))
+}
+
+func (ms *memoryStore) Latest(city string) (Measurement, bool) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	series := ms.series[city]
+	if len(series) == 0 {
+		return Measurement{}, false
+	}
+
+	return series[len(series)-1], true
+}
+
+func (ms *memoryStore) History(city string, from, to time.Time) ([]Measurement, bool) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	series, ok := ms.series[city]
+	if !ok {
+		return nil, false
+	}
+
+	start := 0
+	if !from.IsZero() {
+		start, _ = slices.BinarySearchFunc(series, from, compareTime)
+	}
+
+	end := len(series)
+	if !to.IsZero() {
+		end, _ = slices.BinarySearchFunc(series, to, compareTime)
+	}
+
+	if start >= end {
+		return []Measurement{}, true
+	}
+
+	// Copy the result so it cannot be affected by later insertions.
+	return slices.Clone(series[start:end]), true
+}
+
+func (*memoryStore) Close() error { return nil }
+
+// compareTime finds the first measurement at or after `ts`.
+func compareTime(msg Measurement, ts time.Time) int {
+	return msg.Time.Compare(ts)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that measurements are returned ordered by time, even if they were
+// added out of order, that the time range is applied correctly, and that
+// measurements of the same time are not stored twice, whether they are equal
+// or not.
+func TestMemoryStoreHistory(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+
+	for _, offset := range []int{2, 0, 3, 1} {
+		err := store.Add("Berlin", TempMessage{
+			Temp: offset,
+			Time: base.Add(time.Duration(offset) * time.Minute),
+		}.Measurement())
+		require.NoError(t, err)
+	}
+
+	history, ok := store.History("Berlin", time.Time{}, time.Time{})
+	require.True(t, ok)
+	require.Len(t, history, 4)
+	for i, msg := range history {
+		require.InDelta(t, float64(i), msg.Temperature.Value, 1e-9)
+	}
+
+	history, ok = store.History("Berlin", base.Add(time.Minute), base.Add(3*time.Minute))
+	require.True(t, ok)
+	require.Len(t, history, 2)
+	require.InDelta(t, 1.0, history[0].Temperature.Value, 1e-9)
+	require.InDelta(t, 2.0, history[1].Temperature.Value, 1e-9)
+
+	latest, ok := store.Latest("Berlin")
+	require.True(t, ok)
+	require.InDelta(t, 3.0, latest.Temperature.Value, 1e-9)
+
+	_, ok = store.History("Hamburg", time.Time{}, time.Time{})
+	require.False(t, ok)
+
+	err := store.Add("Berlin", TempMessage{Temp: 1, Time: base.Add(time.Minute).Local()}.Measurement())
+	require.ErrorIs(t, err, ErrDuplicate)
+	err = store.Add("Berlin", TempMessage{Temp: 9, Time: base.Add(time.Minute)}.Measurement())
+	require.ErrorIs(t, err, ErrConflict)
+	history, _ = store.History("Berlin", time.Time{}, time.Time{})
+	require.Len(t, history, 4)
+}
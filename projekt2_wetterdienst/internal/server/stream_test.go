@@ -0,0 +1,431 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/config"
+)
+
+// Ensures that event streams announce the retry interval, replay events
+// missed since `Last-Event-ID`, and send heartbeats.
+func TestStreamResume(t *testing.T) {
+	streamCfg := config.C.Stream
+	config.C.Stream.HeartbeatInterval = 20 * time.Millisecond
+	t.Cleanup(func() { config.C.Stream = streamCfg })
+
+	svr, ctx := startStreamer(t)
+	events := postEvents(t, ctx, svr.Broker, "SSEBerlin", "SSEBerlin", "SSEBerlin")
+	require.Less(t, events[0].ID, events[1].ID)
+	require.Less(t, events[1].ID, events[2].ID)
+
+	lines := openStream(t, svr, "/cities/SSEBerlin/stream", events[0].ID)
+	require.Equal(t, "retry: 3000", next(t, lines))
+	require.Empty(t, next(t, lines))
+
+	for _, event := range events[1:] {
+		require.Equal(t, fmt.Sprintf("id: %d", event.ID), next(t, lines))
+		require.Contains(t, next(t, lines), "data: {")
+		require.Empty(t, next(t, lines))
+	}
+
+	require.Equal(t, ": heartbeat", next(t, lines))
+}
+
+// Ensures that the multiplexed stream delivers the events of the requested
+// cities, or of all cities, in order and tagged with their city.
+func TestStreamCities(t *testing.T) {
+	t.Parallel()
+
+	svr, ctx := startStreamer(t)
+	events := postEvents(t, ctx, svr.Broker, "SSEA", "SSEB", "SSEC", "SSEA")
+	after := events[0].ID - 1
+
+	receive := func(path string, expected ...Event) {
+		lines := openStream(t, svr, path, after)
+		require.Equal(t, "retry: 3000", next(t, lines))
+		require.Empty(t, next(t, lines))
+
+		for _, event := range expected {
+			require.Equal(t, fmt.Sprintf("id: %d", event.ID), next(t, lines))
+
+			var data StreamEvent
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(next(t, lines), "data: ")), &data))
+			require.Equal(t, event.City, data.City)
+			require.Empty(t, next(t, lines))
+		}
+	}
+
+	receive("/stream?cities=SSEA,%20SSEB", events[0], events[1], events[3])
+	receive("/stream?cities=*", events...)
+}
+
+// Ensures that `AllCities` cannot be subscribed to as a single city, neither
+// by the broker nor by the stream and WebSocket endpoints.
+func TestListenAllCities(t *testing.T) {
+	t.Parallel()
+
+	svr, ctx := startStreamer(t)
+
+	sub := svr.Broker.Listen(ctx, AllCities, SubscribeOptions{})
+	_, ok := <-sub.Events()
+	require.False(t, ok)
+	require.ErrorIs(t, sub.Err(), ErrInvalidCity)
+
+	for path, handler := range map[string]http.HandlerFunc{
+		"/cities/*/stream": svr.getCitiesNameStream,
+		"/cities/*/ws":     svr.getCitiesNameWS,
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.SetPathValue("name", AllCities)
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		require.Equal(t, http.StatusBadRequest, rec.Code, path)
+	}
+}
+
+// Ensures that subscriptions which do not keep up drop events according to
+// their policy and announce how many were missed.
+func TestSubscriptionOverflow(t *testing.T) {
+	t.Parallel()
+
+	fill := func(overflow string, count int) (*subscription, bool) {
+		sub := &subscription{msgChan: make(chan Event, 2), overflow: overflow}
+
+		ok := true
+		for id := range count {
+			ok = sub.send(Event{ID: uint64(id + 1), City: "OverflowCity"})
+			if !ok {
+				break
+			}
+		}
+		return sub, ok
+	}
+
+	receive := func(sub *subscription) []Event {
+		var events []Event
+		for range len(sub.msgChan) {
+			event := <-sub.msgChan
+			event.Measurement = Measurement{}
+			events = append(events, event)
+		}
+		return events
+	}
+
+	sub, ok := fill(config.OverflowDropNewest, 4)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), sub.Dropped())
+	require.Equal(t, []Event{{ID: 1, City: "OverflowCity"}, {ID: 2, City: "OverflowCity"}}, receive(sub))
+	require.True(t, sub.send(Event{ID: 5, City: "OverflowCity"}))
+	require.Equal(t, []Event{{ID: 5, City: "OverflowCity", Missed: 2}}, receive(sub))
+
+	sub, ok = fill(config.OverflowDropOldest, 4)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), sub.Dropped())
+	require.Equal(t, []Event{{ID: 3, City: "OverflowCity", Missed: 1}, {ID: 4, City: "OverflowCity", Missed: 1}}, receive(sub))
+
+	maxDrops := config.C.Stream.MaxDrops
+	sub, ok = fill(config.OverflowDisconnect, 2+maxDrops-1)
+	require.True(t, ok)
+	require.NoError(t, sub.Err())
+
+	sub, ok = fill(config.OverflowDisconnect, 2+maxDrops)
+	require.False(t, ok)
+	require.Equal(t, uint64(maxDrops), sub.Dropped())
+	require.ErrorIs(t, sub.Err(), ErrSlowConsumer)
+}
+
+// Ensures that subscribers are reported with their details and removed as
+// soon as their context is done, even if nothing is posted.
+func TestSubscribers(t *testing.T) {
+	t.Parallel()
+
+	svr, ctx := startStreamer(t)
+
+	subscribers := func() []CitySubscribers {
+		allSubs, err := svr.Broker.Subscribers(ctx)
+		require.NoError(t, err)
+		return allSubs
+	}
+
+	cityCtx, cancel := context.WithCancel(ctx)
+	sub := svr.Broker.Listen(cityCtx, "SubscriberCity", SubscribeOptions{RemoteAddr: "192.0.2.1:1234"})
+	svr.Broker.ListenCities(ctx, []string{"SubscriberB", "SubscriberA"}, SubscribeOptions{Overflow: config.OverflowDisconnect})
+
+	require.Eventually(t, func() bool { return len(subscribers()) == 2 }, time.Second, time.Millisecond)
+
+	allSubs := subscribers()
+	require.Equal(t, AllCities, allSubs[0].City)
+	require.Len(t, allSubs[0].Subscribers, 1)
+	require.Equal(t, []string{"SubscriberA", "SubscriberB"}, allSubs[0].Subscribers[0].Cities)
+	require.Equal(t, config.OverflowDisconnect, allSubs[0].Subscribers[0].Overflow)
+
+	require.Equal(t, "SubscriberCity", allSubs[1].City)
+	require.Len(t, allSubs[1].Subscribers, 1)
+	info := allSubs[1].Subscribers[0]
+	require.Equal(t, "192.0.2.1:1234", info.RemoteAddr)
+	require.Equal(t, config.C.Stream.Overflow, info.Overflow)
+	require.WithinDuration(t, time.Now(), info.Connected, time.Second)
+
+	cancel()
+	require.Eventually(t, func() bool { return len(subscribers()) == 1 }, time.Second, time.Millisecond)
+	_, ok := <-sub.Events()
+	require.False(t, ok)
+}
+
+// Ensures that measurements are rejected once the post queue stays full.
+func TestStreamerBusy(t *testing.T) {
+	t.Parallel()
+
+	// The streamer does not run, so nothing is taken from the queue.
+	streamer := NewStreamer()
+	msg := TempMessage{20, time.Now()}.Measurement()
+	for range config.C.Stream.PostQueue {
+		require.NoError(t, streamer.Post(context.Background(), "BusyCity", msg))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, streamer.Post(ctx, "BusyCity", msg), ErrBrokerBusy)
+}
+
+// Ensures that posting to a busy shard waits and fails with `ErrBrokerBusy`
+// instead of dropping events.
+func TestShardBusy(t *testing.T) {
+	t.Parallel()
+
+	sh := newShard()
+	sh.eventChan = make(chan Event, 1)
+	require.NoError(t, sh.post(context.Background(), Event{ID: 1, City: "ShardCity"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, sh.post(ctx, Event{ID: 2, City: "ShardCity"}), ErrBrokerBusy)
+
+	require.Equal(t, Event{ID: 1, City: "ShardCity"}, <-sh.eventChan)
+	require.NoError(t, sh.post(context.Background(), Event{ID: 3, City: "ShardCity"}))
+}
+
+// Measures how fast measurements of 100 cities are distributed to many
+// subscribers, a tenth of which subscribe to all cities. Compares the
+// streamer before sharding with one and several shards. Reports
+// the time from posting to receiving measurements separately for subscribers
+// of single cities and of all cities.
+func BenchmarkStreamer(b *testing.B) {
+	designs := []struct {
+		name      string
+		newBroker func() runningBroker
+	}{
+		{"single-loop", func() runningBroker { return newSingleLoopStreamer() }},
+		{"shards=1", func() runningBroker { return newStreamerWithShards(1) }},
+		{"shards=8", func() runningBroker { return newStreamerWithShards(8) }},
+	}
+
+	for _, subscribers := range []int{1000, 10000} {
+		for _, design := range designs {
+			b.Run(fmt.Sprintf("subscribers=%d/%s", subscribers, design.name), func(b *testing.B) {
+				benchmarkStreamer(b, design.newBroker(), subscribers)
+			})
+		}
+	}
+}
+
+func benchmarkStreamer(b *testing.B, broker runningBroker, subscribers int) {
+	ctx := startBroker(b, broker)
+
+	cities := make([]string, 100)
+	for idx := range cities {
+		cities[idx] = fmt.Sprintf("BenchCity%d", idx)
+	}
+
+	type latencies struct {
+		count      int
+		total, max time.Duration
+	}
+	results := make([]latencies, subscribers)
+	subs := make([]Subscription, subscribers)
+	allCities := func(idx int) bool { return idx%10 == 0 }
+
+	// Dropping the oldest events ensures that the final measurement without
+	// time, which ends the subscribers, is received.
+	var wg sync.WaitGroup
+	for idx := range subscribers {
+		opts := SubscribeOptions{Overflow: config.OverflowDropOldest}
+		if allCities(idx) {
+			subs[idx] = broker.ListenCities(ctx, nil, opts)
+		} else {
+			subs[idx] = broker.Listen(ctx, cities[idx%len(cities)], opts)
+		}
+
+		wg.Go(func() {
+			for event := range subs[idx].Events() {
+				if event.Time.IsZero() {
+					return
+				}
+
+				latency := time.Since(event.Time)
+				results[idx].count++
+				results[idx].total += latency
+				results[idx].max = max(results[idx].max, latency)
+			}
+		})
+	}
+
+	require.Eventually(b, func() bool {
+		allSubs, err := broker.Subscribers(ctx)
+		require.NoError(b, err)
+
+		count := 0
+		for _, citySubs := range allSubs {
+			count += len(citySubs.Subscribers)
+		}
+		return count == subscribers
+	}, 10*time.Second, time.Millisecond)
+
+	b.ResetTimer()
+	for idx := range b.N {
+		require.NoError(b, broker.Post(ctx, cities[idx%len(cities)], TempMessage{20, time.Now()}.Measurement()))
+	}
+
+	for _, city := range cities {
+		require.NoError(b, broker.Post(ctx, city, Measurement{}))
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	var city, all latencies
+	dropped := uint64(0)
+	for idx, result := range results {
+		total := &city
+		if allCities(idx) {
+			total = &all
+		}
+		total.count += result.count
+		total.total += result.total
+		total.max = max(total.max, result.max)
+		dropped += subs[idx].Dropped()
+	}
+
+	b.ReportMetric(float64(city.count+all.count)/b.Elapsed().Seconds(), "deliveries/s")
+	b.ReportMetric(float64(dropped)/float64(b.N), "dropped/op")
+	for name, total := range map[string]latencies{"city": city, "all": all} {
+		if total.count > 0 {
+			b.ReportMetric(float64(total.total.Microseconds())/float64(total.count), name+"-µs-mean")
+		}
+		b.ReportMetric(float64(total.max.Microseconds()), name+"-µs-max")
+	}
+}
+
+// runningBroker is a broker which delivers events while it runs.
+type runningBroker interface {
+	Broker
+	Run(ctx context.Context) error
+}
+
+func newStreamerWithShards(shards int) *Streamer {
+	streamCfg := config.C.Stream
+	defer func() { config.C.Stream = streamCfg }()
+
+	config.C.Stream.Shards = shards
+	return NewStreamer()
+}
+
+// startStreamer runs a streamer until the test ends and returns a server
+// using it.
+func startStreamer(t testing.TB) (*Server, context.Context) {
+	streamer := NewStreamer()
+	return &Server{Broker: streamer}, startBroker(t, streamer)
+}
+
+// startBroker runs the broker until the test ends.
+func startBroker(t testing.TB, broker runningBroker) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = broker.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	return ctx
+}
+
+// postEvents posts a measurement for each city. The returned events are
+// buffered for replays.
+func postEvents(t *testing.T, ctx context.Context, broker Broker, cities ...string) []Event {
+	t.Helper()
+
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgChan := broker.ListenCities(listenCtx, nil, SubscribeOptions{}).Events()
+
+	// Measurements are posted until the listener is registered.
+	for registered := false; !registered; {
+		require.NoError(t, broker.Post(ctx, "SSEWarmup", TempMessage{20, time.Now()}.Measurement()))
+
+		select {
+		case <-msgChan:
+			registered = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	var events []Event
+	for _, city := range cities {
+		require.NoError(t, broker.Post(ctx, city, TempMessage{20, time.Now()}.Measurement()))
+
+		// Skips measurements of the warmup which were still queued.
+		for event := range msgChan {
+			if event.City == city {
+				events = append(events, event)
+				break
+			}
+		}
+	}
+
+	return events
+}
+
+// openStream requests an event stream which is closed when the test ends.
+func openStream(t *testing.T, svr *Server, path string, lastEventID uint64) *bufio.Scanner {
+	router := http.NewServeMux()
+	router.HandleFunc("GET /cities/{name}/stream", svr.getCitiesNameStream)
+	router.HandleFunc("GET /stream", svr.getStream)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(lastEventID, 10))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	return bufio.NewScanner(resp.Body)
+}
+
+func next(t *testing.T, lines *bufio.Scanner) string {
+	require.True(t, lines.Scan())
+	return lines.Text()
+}
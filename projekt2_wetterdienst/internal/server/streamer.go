@@ -1,93 +1,283 @@
 package server
 
 import (
+	"cmp"
 	"context"
-)
+	"hash/fnv"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
-var (
-	postChan = make(chan postMsg, 256)
-	listChan = make(chan listenerMsg, 256)
+	"github.com/risingwavelabs/eris"
 
-	listeners = map[string][]listener{}
+	"weather-service/internal/config"
+	"weather-service/internal/logging"
 )
 
+var streamLogger = logging.For("streamer")
+
 type postMsg struct {
-	TempMessage
-	city string
+	Measurement
+	city   string
+	queued time.Time
+}
+
+// Streamer is the in-process `Broker`. It only delivers events while it runs
+// as a service. Its loop numbers the posted measurements and passes them to the
+// shards, which distribute them to the subscribers.
+type Streamer struct {
+	postChan chan postMsg
+	pingChan chan struct{}
+
+	// Topics are assigned to shards by hash.
+	shards []*shard
+
+	// ID of the last posted event.
+	lastID uint64
 }
 
-type listenerMsg struct {
-	listener
-	city string
+func NewStreamer() *Streamer {
+	count := config.C.Stream.Shards
+	if count <= 0 {
+		count = runtime.GOMAXPROCS(0)
+	}
+
+	str := &Streamer{
+		postChan: make(chan postMsg, config.C.Stream.PostQueue),
+		pingChan: make(chan struct{}),
+	}
+	for range count {
+		str.shards = append(str.shards, newShard())
+	}
+	return str
 }
 
-type listener struct {
-	ctx     context.Context
-	msgChan chan TempMessage
+// subscription is a `Subscription` of the streamer.
+type subscription struct {
+	ctx      context.Context
+	msgChan  chan Event
+	topic    string
+	after    uint64
+	overflow string
+
+	remoteAddr string
+	connected  time.Time
+
+	// Unregisters the removal once the context is done.
+	stop func() bool
+
+	// Cities of subscriptions of `AllCities`. All cities if empty.
+	cities map[string]bool
+
+	// Events dropped since the last delivered one. Only used by the streamer.
+	missed uint64
+
+	dropped atomic.Uint64
+	err     error
+}
+
+func (sub *subscription) Events() <-chan Event { return sub.msgChan }
+func (sub *subscription) Dropped() uint64      { return sub.dropped.Load() }
+func (sub *subscription) Err() error           { return sub.err }
+
+// rejectedSubscription returns a subscription which ended with the error.
+func rejectedSubscription(err error) *subscription {
+	sub := &subscription{
+		msgChan: make(chan Event),
+		stop:    func() bool { return false },
+		err:     err,
+	}
+	close(sub.msgChan)
+	return sub
 }
 
-type Streamer struct{}
+func (sub *subscription) wants(city string) bool {
+	return len(sub.cities) == 0 || sub.cities[city]
+}
+
+// send delivers the event or applies the overflow policy if the buffer is
+// full. It returns false if the subscriber must be disconnected.
+func (sub *subscription) send(event Event) bool {
+	event.Missed = sub.missed
+
+	select {
+	case sub.msgChan <- event:
+		sub.missed = 0
+		return true
+	default:
+	}
+
+	sub.dropped.Add(1)
+	streamDropped.Inc(event.City)
+
+	switch sub.overflow {
+	case config.OverflowDropOldest:
+		// Only the streamer sends, so there is room afterwards.
+		select {
+		case oldest := <-sub.msgChan:
+			event.Missed += 1 + oldest.Missed
+		default:
+		}
+		sub.msgChan <- event
+		sub.missed = 0
+
+	case config.OverflowDisconnect:
+		if sub.dropped.Load() >= uint64(config.C.Stream.MaxDrops) {
+			sub.err = ErrSlowConsumer
+			return false
+		}
+		sub.missed++
+
+	default:
+		sub.missed++
+	}
+
+	return true
+}
+
+// eventRing keeps the last events of a city.
+type eventRing struct {
+	events []Event
+	next   int
+}
+
+func (ring *eventRing) add(event Event) {
+	if len(ring.events) < cap(ring.events) {
+		ring.events = append(ring.events, event)
+		return
+	}
+
+	ring.events[ring.next] = event
+	ring.next = (ring.next + 1) % len(ring.events)
+}
+
+// after returns the events with a greater ID, oldest first.
+func (ring *eventRing) after(id uint64) []Event {
+	var events []Event
+	for idx := range ring.events {
+		event := ring.events[(ring.next+idx)%len(ring.events)]
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+	return events
+}
 
 func (str *Streamer) Name() string                   { return "Streamer" }
 func (str *Streamer) Init(ctx context.Context) error { return nil }
 func (str *Streamer) Stop() error                    { return nil }
 
+// Check ensures that the loops of `Run()` and of all shards still handle
+// messages.
+func (str *Streamer) Check(ctx context.Context) error {
+	select {
+	case str.pingChan <- struct{}{}:
+	case <-ctx.Done():
+		return eris.New("streamer is not responding")
+	}
+
+	for _, sh := range str.shards {
+		err := sh.check(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (str *Streamer) Run(ctx context.Context) error {
+	if str.lastID == 0 {
+		str.lastID = uint64(time.Now().UnixMicro())
+	}
+
+	var wg sync.WaitGroup
+	for _, sh := range str.shards {
+		wg.Go(func() { sh.run(ctx) })
+	}
+
+	// Listeners of several cities get all events from one shard, which
+	// receives them in the order of their IDs.
+	allShard := str.shardFor(AllCities)
+
 	for done := false; !done; {
 		select {
 		case <-ctx.Done():
 			done = true
-			continue
 
-		case msg := <-postChan:
-			listList := listeners[msg.city]
-			for idx := 0; idx < len(listList); idx++ {
-				listener := &listList[idx]
+		case msg := <-str.postChan:
+			streamQueued.Dec()
+			streamQueueTime.Observe(time.Since(msg.queued).Seconds())
 
-				select {
-				case <-listener.ctx.Done():
-					close(listener.msgChan)
+			str.lastID++
+			event := Event{ID: str.lastID, City: msg.city, Measurement: msg.Measurement}
 
-					// Remove by swapping with last.
-					lastIdx := len(listList) - 1
-					listList[idx] = listList[lastIdx]
-					listList = listList[:lastIdx]
-
-					idx--
-					continue
-
-				default:
-				}
-
-				// Second select to give priority to ctx.Done().
-				select {
-				case listener.msgChan <- msg.TempMessage:
-				default:
-				}
+			cityShard := str.shardFor(msg.city)
+			err := cityShard.post(ctx, event)
+			if err == nil && cityShard != allShard {
+				err = allShard.post(ctx, event)
 			}
-			listeners[msg.city] = listList
+			done = err != nil
 
-		case reg := <-listChan:
-			listeners[reg.city] = append(listeners[reg.city], reg.listener)
+		case <-str.pingChan:
 		}
 	}
 
-	for _, listenerList := range listeners {
-		for _, listener := range listenerList {
-			close(listener.msgChan)
-		}
+	wg.Wait()
+	return nil
+}
+
+func (str *Streamer) shardFor(topic string) *shard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(topic))
+	return str.shards[hash.Sum32()%uint32(len(str.shards))]
+}
+
+func (str *Streamer) Post(ctx context.Context, city string, msg Measurement) error {
+	// Waiting measurements count as queued, so the gauge is never negative.
+	streamQueued.Inc()
+
+	select {
+	case str.postChan <- postMsg{msg, city, time.Now()}:
+		return nil
+	case <-ctx.Done():
+		streamQueued.Dec()
+		streamRejected.Inc()
+		return ErrBrokerBusy
 	}
+}
 
-	return nil
+func (str *Streamer) Listen(ctx context.Context, city string, opts SubscribeOptions) Subscription {
+	if city == AllCities {
+		return rejectedSubscription(ErrInvalidCity)
+	}
+	return str.shardFor(city).listen(ctx, city, nil, opts)
 }
 
-func Post(city string, msg TempMessage) {
-	postChan <- postMsg{msg, city}
+func (str *Streamer) ListenCities(ctx context.Context, cities []string, opts SubscribeOptions) Subscription {
+	filter := map[string]bool{}
+	for _, city := range cities {
+		filter[city] = true
+	}
+	return str.shardFor(AllCities).listen(ctx, AllCities, filter, opts)
 }
 
-func Listen(ctx context.Context, city string) <-chan TempMessage {
-	msgChan := make(chan TempMessage, 256)
-	listChan <- listenerMsg{listener{ctx, msgChan}, city}
+func (str *Streamer) Subscribers(ctx context.Context) ([]CitySubscribers, error) {
+	allSubs := []CitySubscribers{}
+	for _, sh := range str.shards {
+		reply := make(chan []CitySubscribers, 1)
+		select {
+		case sh.infoChan <- reply:
+			allSubs = append(allSubs, <-reply...)
+		case <-ctx.Done():
+			return nil, eris.New("streamer is not responding")
+		}
+	}
 
-	return msgChan
+	// Each topic belongs to a single shard.
+	slices.SortFunc(allSubs, func(a, b CitySubscribers) int {
+		return cmp.Compare(a.City, b.City)
+	})
+	return allSubs, nil
 }
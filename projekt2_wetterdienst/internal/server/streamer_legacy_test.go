@@ -0,0 +1,293 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/risingwavelabs/eris"
+
+	"weather-service/internal/config"
+)
+
+// singleLoopStreamer is the `Streamer` before it was split into shards. A
+// single loop delivers all events. It is kept to benchmark the shards.
+type singleLoopStreamer struct {
+	postChan   chan postMsg
+	listChan   chan *subscription
+	unlistChan chan *subscription
+	infoChan   chan chan []CitySubscribers
+	pingChan   chan struct{}
+
+	// Subscriptions by city or `AllCities`. Only used by `Run()`.
+	listeners map[string][]*subscription
+
+	// Recent events of each city for listeners which resume a stream.
+	history map[string]*eventRing
+
+	// ID of the last posted event.
+	lastID uint64
+}
+
+func newSingleLoopStreamer() *singleLoopStreamer {
+	return &singleLoopStreamer{
+		postChan:   make(chan postMsg, config.C.Stream.PostQueue),
+		listChan:   make(chan *subscription, 256),
+		unlistChan: make(chan *subscription, 256),
+		infoChan:   make(chan chan []CitySubscribers),
+		pingChan:   make(chan struct{}),
+		listeners:  map[string][]*subscription{},
+		history:    map[string]*eventRing{},
+	}
+}
+
+func (str *singleLoopStreamer) Run(ctx context.Context) error {
+	if str.lastID == 0 {
+		str.lastID = uint64(time.Now().UnixMicro())
+	}
+
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+			continue
+
+		case msg := <-str.postChan:
+			streamQueued.Dec()
+			streamQueueTime.Observe(time.Since(msg.queued).Seconds())
+
+			str.lastID++
+			event := Event{ID: str.lastID, City: msg.city, Measurement: msg.Measurement}
+			str.record(event)
+
+			str.deliver(msg.city, event)
+			str.deliver(AllCities, event)
+
+		case <-str.pingChan:
+
+		case reply := <-str.infoChan:
+			reply <- str.subscribers()
+
+		case sub := <-str.unlistChan:
+			str.remove(sub)
+
+		case sub := <-str.listChan:
+			// The removal might have been handled before.
+			if sub.ctx.Err() != nil {
+				sub.stop()
+				close(sub.msgChan)
+				continue
+			}
+
+			// Missed events are replayed before any new ones, so none are
+			// lost or duplicated.
+			if sub.after > 0 {
+				for _, event := range str.replay(sub) {
+					sub.msgChan <- event
+				}
+			}
+
+			str.listeners[sub.topic] = append(str.listeners[sub.topic], sub)
+			streamLogger.Debug("added listener", "city", sub.topic)
+			streamSubscribers.Inc(sub.topic)
+		}
+	}
+
+	for city, listenerList := range str.listeners {
+		for _, sub := range listenerList {
+			sub.stop()
+			close(sub.msgChan)
+		}
+		delete(str.listeners, city)
+		streamSubscribers.Delete(city)
+	}
+	clear(str.history)
+
+	return nil
+}
+
+// deliver sends the event to the subscriptions of the topic and removes
+// those whose context is done or which must be disconnected.
+func (str *singleLoopStreamer) deliver(topic string, event Event) {
+	var ended []*subscription
+	for _, sub := range str.listeners[topic] {
+		switch {
+		case sub.ctx.Err() != nil:
+			ended = append(ended, sub)
+
+		case sub.wants(event.City) && !sub.send(event):
+			streamLogger.Warn("disconnected slow subscriber", "city", event.City, "dropped", sub.Dropped())
+			streamDisconnects.Inc(event.City)
+			ended = append(ended, sub)
+		}
+	}
+
+	for _, sub := range ended {
+		str.remove(sub)
+	}
+}
+
+// remove closes the subscription unless it was removed before.
+func (str *singleLoopStreamer) remove(sub *subscription) {
+	listList := str.listeners[sub.topic]
+	idx := slices.Index(listList, sub)
+	if idx < 0 {
+		return
+	}
+
+	sub.stop()
+	close(sub.msgChan)
+
+	// Remove by swapping with last.
+	lastIdx := len(listList) - 1
+	listList[idx] = listList[lastIdx]
+	listList[lastIdx] = nil
+	listList = listList[:lastIdx]
+	streamLogger.Debug("removed listener", "city", sub.topic)
+
+	if len(listList) == 0 {
+		// Cities are taken from requests, so series of cities
+		// without listeners are removed.
+		delete(str.listeners, sub.topic)
+		streamSubscribers.Delete(sub.topic)
+	} else {
+		str.listeners[sub.topic] = listList
+		streamSubscribers.Dec(sub.topic)
+	}
+}
+
+// replay returns the buffered events of the new subscription which are newer
+// than `sub.after`, oldest first. Only the newest ones fit into its channel.
+func (str *singleLoopStreamer) replay(sub *subscription) []Event {
+	var events []Event
+	if sub.topic == AllCities {
+		for city, ring := range str.history {
+			if sub.wants(city) {
+				events = append(events, ring.after(sub.after)...)
+			}
+		}
+		slices.SortFunc(events, func(a, b Event) int { return cmp.Compare(a.ID, b.ID) })
+	} else if ring, ok := str.history[sub.topic]; ok {
+		events = ring.after(sub.after)
+	}
+
+	return events[max(0, len(events)-cap(sub.msgChan)):]
+}
+
+func (str *singleLoopStreamer) record(event Event) {
+	size := config.C.Stream.ReplayBuffer
+	if size <= 0 {
+		return
+	}
+
+	ring, ok := str.history[event.City]
+	if !ok {
+		ring = &eventRing{events: make([]Event, 0, size)}
+		str.history[event.City] = ring
+	}
+	ring.add(event)
+}
+
+func (str *singleLoopStreamer) Post(ctx context.Context, city string, msg Measurement) error {
+	// Waiting measurements count as queued, so the gauge is never negative.
+	streamQueued.Inc()
+
+	select {
+	case str.postChan <- postMsg{msg, city, time.Now()}:
+		return nil
+	case <-ctx.Done():
+		streamQueued.Dec()
+		streamRejected.Inc()
+		return ErrBrokerBusy
+	}
+}
+
+func (str *singleLoopStreamer) Listen(ctx context.Context, city string, opts SubscribeOptions) Subscription {
+	return str.listen(ctx, city, nil, opts)
+}
+
+func (str *singleLoopStreamer) ListenCities(ctx context.Context, cities []string, opts SubscribeOptions) Subscription {
+	filter := map[string]bool{}
+	for _, city := range cities {
+		filter[city] = true
+	}
+	return str.listen(ctx, AllCities, filter, opts)
+}
+
+func (str *singleLoopStreamer) listen(ctx context.Context, topic string, cities map[string]bool, opts SubscribeOptions) *subscription {
+	if len(opts.Overflow) == 0 {
+		opts.Overflow = config.C.Stream.Overflow
+	}
+
+	sub := &subscription{
+		ctx: ctx,
+		// Replayed events of a single city fit into the channel.
+		msgChan:    make(chan Event, max(256, config.C.Stream.ReplayBuffer)),
+		topic:      topic,
+		after:      opts.After,
+		overflow:   opts.Overflow,
+		remoteAddr: opts.RemoteAddr,
+		connected:  time.Now(),
+		cities:     cities,
+	}
+
+	// Subscriptions are removed as soon as their context is done, even if no
+	// more events are posted for their cities.
+	sub.stop = context.AfterFunc(ctx, func() {
+		for {
+			select {
+			case str.unlistChan <- sub:
+				return
+
+			// The events are of no use anymore. The channel is closed once
+			// the streamer removed the subscription.
+			case _, ok := <-sub.msgChan:
+				if !ok {
+					return
+				}
+			}
+		}
+	})
+	str.listChan <- sub
+
+	return sub
+}
+
+func (str *singleLoopStreamer) Subscribers(ctx context.Context) ([]CitySubscribers, error) {
+	reply := make(chan []CitySubscribers, 1)
+	select {
+	case str.infoChan <- reply:
+		return <-reply, nil
+	case <-ctx.Done():
+		return nil, eris.New("streamer is not responding")
+	}
+}
+
+func (str *singleLoopStreamer) subscribers() []CitySubscribers {
+	allSubs := make([]CitySubscribers, 0, len(str.listeners))
+	for topic, listList := range str.listeners {
+		citySubs := CitySubscribers{City: topic}
+		for _, sub := range listList {
+			citySubs.Subscribers = append(citySubs.Subscribers, SubscriberInfo{
+				Cities:     slices.Sorted(maps.Keys(sub.cities)),
+				RemoteAddr: sub.remoteAddr,
+				Connected:  sub.connected,
+				Overflow:   sub.overflow,
+				Buffered:   len(sub.msgChan),
+				Dropped:    sub.Dropped(),
+			})
+		}
+
+		slices.SortFunc(citySubs.Subscribers, func(a, b SubscriberInfo) int {
+			return a.Connected.Compare(b.Connected)
+		})
+		allSubs = append(allSubs, citySubs)
+	}
+
+	slices.SortFunc(allSubs, func(a, b CitySubscribers) int {
+		return cmp.Compare(a.City, b.City)
+	})
+	return allSubs
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/risingwavelabs/eris"
+
+	"weather-service/internal/config"
+)
+
+// tlsReloader serves the certificate and client CAs from files and reloads
+// them when the files change. Connections use the files as they were at the
+// time of their handshake.
+type tlsReloader struct {
+	cfg config.TLSConfig
+
+	current atomic.Pointer[tls.Config]
+
+	// Modification times of the loaded files.
+	modTimes map[string]time.Time
+}
+
+func newTLSReloader(cfg config.TLSConfig) (*tlsReloader, error) {
+	tr := &tlsReloader{cfg: cfg}
+
+	err := tr.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// tlsConfig returns the config for the server which always uses the latest
+// files.
+func (tr *tlsReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+			return tr.current.Load(), nil
+		},
+	}
+}
+
+func (tr *tlsReloader) files() []string {
+	files := []string{tr.cfg.CertFile, tr.cfg.KeyFile}
+	if len(tr.cfg.ClientCAFile) > 0 {
+		files = append(files, tr.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// load reads all files. The current config is kept if any of them is
+// invalid.
+func (tr *tlsReloader) load() error {
+	// Taken before reading so changes while reading are detected next time.
+	modTimes, err := tr.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(tr.cfg.CertFile, tr.cfg.KeyFile)
+	if err != nil {
+		return eris.Wrap(err, "failed to load server certificate")
+	}
+
+	// The config replaces the one of the server for the handshake, so
+	// protocols have to be offered again.
+	tlsCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if len(tr.cfg.ClientCAFile) > 0 {
+		pem, err := os.ReadFile(tr.cfg.ClientCAFile)
+		if err != nil {
+			return eris.Wrap(err, "failed to read client CA file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return eris.Errorf("no certificates in client CA file '%s'", tr.cfg.ClientCAFile)
+		}
+
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if tr.cfg.RequireClientCert {
+			tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	tr.current.Store(tlsCfg)
+	tr.modTimes = modTimes
+
+	return nil
+}
+
+func (tr *tlsReloader) stat() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, file := range tr.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, eris.Wrapf(err, "failed to access '%s'", file)
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// reloadIfChanged loads the files again if any of them was modified since
+// they were loaded last.
+func (tr *tlsReloader) reloadIfChanged() (bool, error) {
+	modTimes, err := tr.stat()
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for file, modTime := range modTimes {
+		changed = changed || !modTime.Equal(tr.modTimes[file])
+	}
+	if !changed {
+		return false, nil
+	}
+
+	return true, tr.load()
+}
+
+// watch periodically reloads changed files until `ctx` is done.
+func (tr *tlsReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(tr.cfg.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := tr.reloadIfChanged()
+		if err != nil {
+			logger.Error("failed to reload TLS files, keeping previous ones", "error", err)
+		} else if reloaded {
+			logger.Info("reloaded TLS files")
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/config"
+)
+
+// writeCert writes a self-signed certificate with the given common name and
+// its key to the given files.
+func writeCert(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+
+	cert, key := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: cn}}, nil, nil)
+	writeCertFiles(t, certFile, keyFile, cert, key)
+}
+
+// newCert creates a certificate from the template, signed by the parent or
+// self-signed if there is none.
+func newCert(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writeCertFiles(t *testing.T, certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+// Ensures that changed certificates are reloaded and that invalid files do not
+// replace the current certificate.
+func TestTLSReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "first")
+
+	tr, err := newTLSReloader(config.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+
+	commonName := func() string {
+		tlsCfg, err := tr.tlsConfig().GetConfigForClient(nil)
+		require.NoError(t, err)
+
+		cert, err := x509.ParseCertificate(tlsCfg.Certificates[0].Certificate[0])
+		require.NoError(t, err)
+		return cert.Subject.CommonName
+	}
+	require.Equal(t, "first", commonName())
+
+	reloaded, err := tr.reloadIfChanged()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// Modification times are set explicitly as the file system might not
+	// be precise enough.
+	touch := func(modTime time.Time) {
+		require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+		require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	}
+
+	writeCert(t, certFile, keyFile, "second")
+	touch(time.Now().Add(time.Minute))
+
+	reloaded, err = tr.reloadIfChanged()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, "second", commonName())
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("invalid"), 0o600))
+	touch(time.Now().Add(2 * time.Minute))
+
+	_, err = tr.reloadIfChanged()
+	require.Error(t, err)
+	require.Equal(t, "second", commonName())
+}
+
+// Ensures that stations are identified by the common name of client
+// certificates signed by the client CA, and that HTTP/2 is offered.
+func TestAuthenticateCertificate(t *testing.T) {
+	authCfg := config.C.Auth
+	config.C.Auth = config.AuthConfig{
+		Stations: []config.StationConfig{{ID: "station-1", CommonName: "station-cn", Cities: []string{"Berlin"}}},
+	}
+	t.Cleanup(func() { config.C.Auth = authCfg })
+
+	dir := t.TempDir()
+	ca, caKey := newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600))
+
+	serverCert, serverKey := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertFiles(t, certFile, keyFile, serverCert, serverKey)
+
+	tr, err := newTLSReloader(config.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	require.NoError(t, err)
+
+	var mayWriteBerlin, mayWriteHamburg bool
+	srv := httptest.NewUnstartedServer(authorize(config.RoleStation, func(w http.ResponseWriter, r *http.Request) {
+		mayWriteBerlin, mayWriteHamburg = mayWrite(r, "Berlin"), mayWrite(r, "Hamburg")
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.EnableHTTP2 = true
+	srv.TLS = tr.tlsConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	post := func(cn string) *http.Response {
+		tlsCfg := &tls.Config{RootCAs: roots}
+		if len(cn) > 0 {
+			cert, key := newCert(t, &x509.Certificate{
+				Subject:     pkix.Name{CommonName: cn},
+				ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}, ca, caKey)
+			tlsCfg.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		}
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg, ForceAttemptHTTP2: true}}
+		resp, err := client.Post(srv.URL+"/cities/Berlin", "application/json", nil)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp := post("station-cn")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, resp.ProtoMajor)
+	require.True(t, mayWriteBerlin)
+	require.False(t, mayWriteHamburg)
+
+	require.Equal(t, http.StatusUnauthorized, post("other-cn").StatusCode)
+	require.Equal(t, http.StatusUnauthorized, post("").StatusCode)
+}
@@ -1,10 +1,116 @@
 package server
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Current version of the measurement format. Version 1 is the `TempMessage`.
+const MeasurementVersion = 2
+
+// Measurement is a set of readings of a station at a given time. All readings
+// are optional and carry their unit.
+type Measurement struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+
+	Temperature   *Quantity `json:"temperature,omitempty"`
+	Humidity      *Quantity `json:"humidity,omitempty"`
+	Pressure      *Quantity `json:"pressure,omitempty"`
+	WindSpeed     *Quantity `json:"windSpeed,omitempty"`
+	WindDirection *Quantity `json:"windDirection,omitempty"`
+	Precipitation *Quantity `json:"precipitation,omitempty"`
+}
+
+// UnmarshalJSON also accepts the format of a `TempMessage` and converts it
+// into the current version.
+func (m *Measurement) UnmarshalJSON(data []byte) error {
+	type measurement Measurement // Prevents recursion.
+
+	var raw struct {
+		measurement
+		Temp *int `json:"temp"`
+	}
+
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	*m = Measurement(raw.measurement)
+	if m.Version <= 1 && raw.Temp != nil {
+		*m = TempMessage{*raw.Temp, m.Time}.Measurement()
+	}
+
+	return nil
+}
+
+// Equal reports whether both measurements have the same version, time, and
+// readings.
+func (m Measurement) Equal(other Measurement) bool {
+	if m.Version != other.Version || !m.Time.Equal(other.Time) {
+		return false
+	}
+
+	for _, rd := range readings {
+		a, b := *rd.field(&m), *rd.field(&other)
+		if (a == nil) != (b == nil) || (a != nil && *a != *b) {
+			return false
+		}
+	}
+	return true
+}
+
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  Unit    `json:"unit"`
+}
+
+type Unit string
+
+// Units in which readings are stored.
+const (
+	UnitCelsius         Unit = "C"
+	UnitPercent         Unit = "%"
+	UnitHectopascal     Unit = "hPa"
+	UnitMetersPerSecond Unit = "m/s"
+	UnitDegree          Unit = "deg"
+	UnitMillimeter      Unit = "mm"
+)
+
+// Further units readings can be converted into.
+const (
+	UnitFahrenheit        Unit = "F"
+	UnitKelvin            Unit = "K"
+	UnitInchesOfMercury   Unit = "inHg"
+	UnitKilometersPerHour Unit = "km/h"
+	UnitMilesPerHour      Unit = "mph"
+	UnitInch              Unit = "in"
+)
+
+// TempMessage is the original measurement format which only reports an
+// integer temperature in °C.
 type TempMessage struct {
 	Temp int       `json:"temp"`
 	Time time.Time `json:"time"`
 }
+
+func (tm TempMessage) Measurement() Measurement {
+	return Measurement{
+		Version:     MeasurementVersion,
+		Time:        tm.Time,
+		Temperature: &Quantity{float64(tm.Temp), UnitCelsius},
+	}
+}
+
+type CitySummary struct {
+	Name       string       `json:"name"`
+	Latest     *Measurement `json:"latest"`
+	LastUpdate *time.Time   `json:"lastUpdate"`
+	Stale      bool         `json:"stale"`
+}
+
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
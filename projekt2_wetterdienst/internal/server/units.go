@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnitSystem selects the units in which readings are returned.
+type UnitSystem string
+
+const (
+	UnitsMetric   UnitSystem = "metric"
+	UnitsImperial UnitSystem = "imperial"
+	UnitsKelvin   UnitSystem = "kelvin"
+)
+
+// unitInfo relates a unit to the unit in which values of the same dimension
+// are stored: `stored = value*scale + offset`.
+type unitInfo struct {
+	stored        Unit
+	scale, offset float64
+
+	// Number of decimals converted values are rounded to.
+	decimals int
+}
+
+var units = map[Unit]unitInfo{
+	UnitCelsius:    {UnitCelsius, 1, 0, 1},
+	UnitFahrenheit: {UnitCelsius, 5.0 / 9, -32 * 5.0 / 9, 1},
+	UnitKelvin:     {UnitCelsius, 1, -273.15, 2},
+
+	UnitPercent: {UnitPercent, 1, 0, 1},
+
+	UnitHectopascal:     {UnitHectopascal, 1, 0, 1},
+	UnitInchesOfMercury: {UnitHectopascal, 33.8639, 0, 2},
+
+	UnitMetersPerSecond:   {UnitMetersPerSecond, 1, 0, 1},
+	UnitKilometersPerHour: {UnitMetersPerSecond, 1 / 3.6, 0, 1},
+	UnitMilesPerHour:      {UnitMetersPerSecond, 0.44704, 0, 1},
+
+	UnitDegree: {UnitDegree, 1, 0, 0},
+
+	UnitMillimeter: {UnitMillimeter, 1, 0, 1},
+	UnitInch:       {UnitMillimeter, 25.4, 0, 2},
+}
+
+// unitSystems maps stored units to the ones used by each system. Units which
+// are not listed are kept.
+var unitSystems = map[UnitSystem]map[Unit]Unit{
+	UnitsMetric: {},
+	UnitsImperial: {
+		UnitCelsius:         UnitFahrenheit,
+		UnitHectopascal:     UnitInchesOfMercury,
+		UnitMetersPerSecond: UnitMilesPerHour,
+		UnitMillimeter:      UnitInch,
+	},
+	UnitsKelvin: {
+		UnitCelsius: UnitKelvin,
+	},
+}
+
+// convertValue converts a value between two units of the same dimension. The
+// result is not rounded.
+func convertValue(value float64, from, to Unit) (float64, error) {
+	fromInfo, ok := units[from]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit '%s'", from)
+	}
+
+	toInfo, ok := units[to]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit '%s'", to)
+	}
+
+	if fromInfo.stored != toInfo.stored {
+		return 0, fmt.Errorf("cannot convert '%s' into '%s'", from, to)
+	}
+
+	stored := value*fromInfo.scale + fromInfo.offset
+	return (stored - toInfo.offset) / toInfo.scale, nil
+}
+
+// roundValue rounds a value to the precision of the given unit.
+func roundValue(value float64, unit Unit) float64 {
+	factor := math.Pow10(units[unit].decimals)
+	return math.Round(value*factor) / factor
+}
+
+// convertUnit returns the unit of the given system for a stored unit.
+func (us UnitSystem) convertUnit(stored Unit) Unit {
+	if unit, ok := unitSystems[us][stored]; ok {
+		return unit
+	}
+	return stored
+}
+
+// convert returns the quantity in the unit of the given system. Converted
+// values are rounded.
+func (us UnitSystem) convert(qty *Quantity) *Quantity {
+	if qty == nil {
+		return nil
+	}
+
+	unit := us.convertUnit(qty.Unit)
+	if unit == qty.Unit {
+		return qty
+	}
+
+	value, err := convertValue(qty.Value, qty.Unit, unit)
+	if err != nil {
+		// Only happens if the tables above are inconsistent.
+		panic(err)
+	}
+
+	return &Quantity{roundValue(value, unit), unit}
+}
+
+// convertMeasurement returns a copy of the measurement with all readings in
+// the units of the given system.
+func (us UnitSystem) convertMeasurement(msg Measurement) Measurement {
+	for _, rd := range readings {
+		field := rd.field(&msg)
+		*field = us.convert(*field)
+	}
+	return msg
+}
+
+// convertStats returns a copy of the statistics in the unit of the given
+// system. As all conversions are linear, they can be applied to the results.
+func (us UnitSystem) convertStats(stats WindowStats) WindowStats {
+	unit := us.convertUnit(stats.Unit)
+	if unit == stats.Unit {
+		return stats
+	}
+
+	for _, value := range []*float64{&stats.Min, &stats.Max, &stats.Mean} {
+		*value = us.convert(&Quantity{*value, stats.Unit}).Value
+	}
+	stats.Unit = unit
+
+	return stats
+}
+
+// parseUnitSystem reads the requested unit system from the `units` query
+// parameter or, if missing, from the `Accept-Units` header. It defaults to
+// metric.
+func parseUnitSystem(r *http.Request) (UnitSystem, error) {
+	value := r.URL.Query().Get("units")
+	if len(value) == 0 {
+		value = r.Header.Get("Accept-Units")
+	}
+	if len(value) == 0 {
+		return UnitsMetric, nil
+	}
+
+	system := UnitSystem(strings.ToLower(strings.TrimSpace(value)))
+	if _, ok := unitSystems[system]; !ok {
+		return "", fmt.Errorf("invalid unit system '%s'", value)
+	}
+
+	return system, nil
+}
+
+// Languages which use a decimal comma in text responses. All others use a
+// decimal point.
+var decimalCommaLanguages = map[string]bool{
+	"cs": true, "da": true, "de": true, "es": true, "fi": true, "fr": true, "it": true,
+	"nb": true, "nl": true, "pl": true, "pt": true, "ru": true, "sv": true, "tr": true,
+}
+
+// parseLanguage returns the primary subtag of the most preferred language of
+// the `Accept-Language` header, or an empty string if there is none.
+func parseLanguage(r *http.Request) string {
+	best, bestQuality := "", 0.0
+	for entry := range strings.SplitSeq(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			quality, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = tag, quality
+		}
+	}
+
+	lang, _, _ := strings.Cut(best, "-")
+	return strings.ToLower(strings.TrimSpace(lang))
+}
+
+// formatValue formats the value with the precision of the unit and the
+// decimal separator of the language.
+func formatValue(value float64, unit Unit, lang string) string {
+	text := strconv.FormatFloat(value, 'f', units[unit].decimals, 64)
+	if decimalCommaLanguages[lang] {
+		text = strings.Replace(text, ".", ",", 1)
+	}
+	return text
+}
+
+// formatMeasurement returns the measurement as text with one line per
+// reading. Numbers are formatted for the language.
+func formatMeasurement(msg Measurement, lang string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "time: %s\n", msg.Time.UTC().Format(time.RFC3339))
+
+	for _, rd := range readings {
+		if qty := *rd.field(&msg); qty != nil {
+			fmt.Fprintf(&sb, "%s: %s %s\n", rd.name, formatValue(qty.Value, qty.Unit, lang), qty.Unit)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that readings are converted into the units of each system and
+// rounded to the precision of the target unit.
+func TestConvertMeasurement(t *testing.T) {
+	t.Parallel()
+
+	msg := Measurement{
+		Version:       MeasurementVersion,
+		Temperature:   &Quantity{21.5, UnitCelsius},
+		Humidity:      &Quantity{40, UnitPercent},
+		Pressure:      &Quantity{1013.25, UnitHectopascal},
+		WindSpeed:     &Quantity{10, UnitMetersPerSecond},
+		Precipitation: &Quantity{12.7, UnitMillimeter},
+	}
+
+	imperial := UnitsImperial.convertMeasurement(msg)
+	require.Equal(t, &Quantity{70.7, UnitFahrenheit}, imperial.Temperature)
+	require.Equal(t, &Quantity{40, UnitPercent}, imperial.Humidity)
+	require.Equal(t, &Quantity{29.92, UnitInchesOfMercury}, imperial.Pressure)
+	require.Equal(t, &Quantity{22.4, UnitMilesPerHour}, imperial.WindSpeed)
+	require.Equal(t, &Quantity{0.5, UnitInch}, imperial.Precipitation)
+	require.Nil(t, imperial.WindDirection)
+
+	kelvin := UnitsKelvin.convertMeasurement(msg)
+	require.Equal(t, &Quantity{294.65, UnitKelvin}, kelvin.Temperature)
+	require.Equal(t, &Quantity{1013.25, UnitHectopascal}, kelvin.Pressure)
+
+	// The original must not be modified.
+	require.Equal(t, &Quantity{21.5, UnitCelsius}, msg.Temperature)
+	require.Equal(t, msg, UnitsMetric.convertMeasurement(msg))
+}
+
+// Ensures that values can be converted back and forth between compatible
+// units only.
+func TestConvertValue(t *testing.T) {
+	t.Parallel()
+
+	value, err := convertValue(-40, UnitFahrenheit, UnitCelsius)
+	require.NoError(t, err)
+	require.InDelta(t, -40.0, value, 1e-9)
+
+	value, err = convertValue(36, UnitKilometersPerHour, UnitMetersPerSecond)
+	require.NoError(t, err)
+	require.InDelta(t, 10.0, value, 1e-9)
+
+	_, err = convertValue(1, UnitCelsius, UnitHectopascal)
+	require.Error(t, err)
+
+	_, err = convertValue(1, Unit("furlong"), UnitMillimeter)
+	require.Error(t, err)
+}
+
+// Ensures that the most preferred language is taken from the
+// `Accept-Language` header.
+func TestParseLanguage(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"":                                "",
+		"de-DE":                           "de",
+		"en-US,en;q=0.9,de;q=0.8":         "en",
+		"fr;q=0.5, DE-AT;q=0.9, en;q=0.1": "de",
+		"*":                               "*",
+		"de;q=x, en;q=0.2":                "en",
+	}
+	for header, lang := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", header)
+		require.Equal(t, lang, parseLanguage(r), header)
+	}
+}
+
+// Ensures that text responses use the precision of each unit and the decimal
+// separator of the language.
+func TestFormatMeasurement(t *testing.T) {
+	t.Parallel()
+
+	msg := Measurement{
+		Version:     MeasurementVersion,
+		Time:        time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC),
+		Temperature: &Quantity{-3.5, UnitCelsius},
+		Pressure:    &Quantity{29.92, UnitInchesOfMercury},
+	}
+
+	require.Equal(t, "time: 2025-09-29T12:00:00Z\ntemperature: -3.5 C\npressure: 29.92 inHg\n", formatMeasurement(msg, "en"))
+	require.Equal(t, "time: 2025-09-29T12:00:00Z\ntemperature: -3,5 C\npressure: 29,92 inHg\n", formatMeasurement(msg, "de"))
+	require.Equal(t, "180", formatValue(180, UnitDegree, "de"))
+}
+
+// Ensures that the latest measurement is returned as text if clients prefer it.
+func TestGetCitiesNameText(t *testing.T) {
+	t.Parallel()
+
+	svr := &Server{store: NewMemoryStore()}
+	ts := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, svr.store.Add("TextCity", TempMessage{21, ts}.Measurement()))
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cities/TextCity?units=imperial", nil)
+		r.SetPathValue("name", "TextCity")
+		r.Header.Set("Accept", accept)
+		r.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+		rec := httptest.NewRecorder()
+		svr.getCitiesName(rec, r)
+		return rec
+	}
+
+	rec := get("text/plain, application/json")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	require.Equal(t, "time: 2025-09-29T12:00:00Z\ntemperature: 69,8 F\n", rec.Body.String())
+
+	rec = get("application/json, text/plain")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"value":69.8`)
+}
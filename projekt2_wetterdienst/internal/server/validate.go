@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"weather-service/internal/config"
+)
+
+// FieldError describes why a single field of a request is invalid. The field
+// is empty if the error concerns the request as a whole.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type quantityInput struct {
+	Value *float64 `json:"value"`
+	Unit  *Unit    `json:"unit"`
+}
+
+// parseMeasurement decodes and validates a measurement of the given city. Both
+// the current format and the `TempMessage` are accepted. All problems found
+// are reported, not only the first one.
+func (svr *Server) parseMeasurement(city string, body []byte, now time.Time) (Measurement, []FieldError) {
+	fields, fieldErr := decodeObject(body)
+	if fieldErr != nil {
+		return Measurement{}, []FieldError{*fieldErr}
+	}
+
+	return svr.validateMeasurement(city, fields, now)
+}
+
+// decodeObject decodes a JSON object without decoding its fields.
+func decodeObject(body []byte) (map[string]json.RawMessage, *FieldError) {
+	var fields map[string]json.RawMessage
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	err := decoder.Decode(&fields)
+	if err != nil {
+		fieldErr := decodeError("", err)
+		return nil, &fieldErr
+	}
+	if fields == nil {
+		return nil, &FieldError{Message: "must be a JSON object"}
+	}
+	if decoder.More() {
+		return nil, &FieldError{Message: "unexpected data after object"}
+	}
+
+	return fields, nil
+}
+
+// validateMeasurement validates the fields of a measurement of the given city.
+func (svr *Server) validateMeasurement(city string, fields map[string]json.RawMessage, now time.Time) (Measurement, []FieldError) {
+	var (
+		msg       Measurement
+		fieldErrs []FieldError
+		err       error
+	)
+
+	if !svr.isKnownCity(city) {
+		fieldErrs = append(fieldErrs, FieldError{"city", fmt.Sprintf("unknown city '%s'", city)})
+	}
+
+	//
+	// Determine version and the fields it allows.
+
+	msg.Version = MeasurementVersion
+	if _, ok := fields["version"]; ok {
+		fieldErr := decodeField(fields, "version", &msg.Version)
+		if fieldErr != nil {
+			return Measurement{}, append(fieldErrs, *fieldErr)
+		}
+		if msg.Version < 1 || msg.Version > MeasurementVersion {
+			return Measurement{}, append(fieldErrs, FieldError{
+				"version",
+				fmt.Sprintf("must be between 1 and %d", MeasurementVersion),
+			})
+		}
+	} else if _, ok := fields["temp"]; ok {
+		msg.Version = 1
+	}
+
+	allowed := []string{"version", "time"}
+	if msg.Version == 1 {
+		allowed = append(allowed, "temp")
+	} else {
+		for _, rd := range readings {
+			allowed = append(allowed, rd.name)
+		}
+	}
+
+	//
+	// Validate fields.
+
+	valCfg := config.C.Validation
+
+	var ts string
+	if fieldErr := decodeField(fields, "time", &ts); fieldErr != nil {
+		fieldErrs = append(fieldErrs, *fieldErr)
+	} else if msg.Time, err = time.Parse(time.RFC3339, ts); err != nil {
+		fieldErrs = append(fieldErrs, FieldError{"time", "must be an RFC 3339 timestamp"})
+	} else if msg.Time.After(now.Add(valCfg.MaxClockSkew)) {
+		fieldErrs = append(fieldErrs, FieldError{
+			"time",
+			fmt.Sprintf("lies more than %s in the future", valCfg.MaxClockSkew),
+		})
+	}
+
+	if msg.Version == 1 {
+		var temp int
+		if fieldErr := decodeField(fields, "temp", &temp); fieldErr != nil {
+			fieldErrs = append(fieldErrs, *fieldErr)
+		} else if fieldErr := checkRange("temp", float64(temp), valCfg.Temperature); fieldErr != nil {
+			fieldErrs = append(fieldErrs, *fieldErr)
+		}
+
+		// Stored in the current version.
+		msg = TempMessage{temp, msg.Time}.Measurement()
+	} else {
+		count := 0
+		for _, rd := range readings {
+			if _, ok := fields[rd.name]; !ok {
+				continue
+			}
+			count++
+
+			qty, qtyErrs := parseQuantity(fields, rd, &valCfg)
+			fieldErrs = append(fieldErrs, qtyErrs...)
+			*rd.field(&msg) = qty
+		}
+
+		if count == 0 {
+			fieldErrs = append(fieldErrs, FieldError{Message: "contains no readings"})
+		}
+	}
+
+	//
+	// Report unknown fields.
+
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if !slices.Contains(allowed, name) {
+			fieldErrs = append(fieldErrs, FieldError{name, "unknown field"})
+		}
+	}
+
+	return msg, fieldErrs
+}
+
+// parseQuantity decodes and validates the given reading.
+func parseQuantity(
+	fields map[string]json.RawMessage,
+	rd reading,
+	valCfg *config.ValidationConfig,
+) (*Quantity, []FieldError) {
+	var input quantityInput
+
+	decoder := json.NewDecoder(bytes.NewReader(fields[rd.name]))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&input)
+	if err != nil {
+		return nil, []FieldError{decodeError(rd.name, err)}
+	}
+
+	var fieldErrs []FieldError
+
+	// Values are converted into the unit in which they are stored. The
+	// latter is assumed if no unit is given.
+	unit := rd.unit
+	if input.Unit != nil {
+		unit = *input.Unit
+	}
+
+	value := 0.0
+	if input.Value == nil {
+		fieldErrs = append(fieldErrs, FieldError{rd.name + ".value", "missing"})
+	} else {
+		value = *input.Value
+	}
+
+	qty := &Quantity{Unit: rd.unit}
+	qty.Value, err = convertValue(value, unit, rd.unit)
+	if err != nil {
+		fieldErrs = append(fieldErrs, FieldError{rd.name + ".unit", err.Error()})
+	} else if input.Value != nil {
+		if fieldErr := checkRange(rd.name+".value", qty.Value, rd.limits(valCfg)); fieldErr != nil {
+			fieldErrs = append(fieldErrs, *fieldErr)
+		}
+	}
+
+	return qty, fieldErrs
+}
+
+// decodeField decodes a required field into `value`.
+func decodeField(fields map[string]json.RawMessage, name string, value any) *FieldError {
+	raw, ok := fields[name]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return &FieldError{name, "missing"}
+	}
+
+	err := json.Unmarshal(raw, value)
+	if err != nil {
+		fieldErr := decodeError(name, err)
+		return &fieldErr
+	}
+
+	return nil
+}
+
+func checkRange(field string, value float64, rng config.Range) *FieldError {
+	if value < rng.Min || value > rng.Max {
+		return &FieldError{field, fmt.Sprintf("must be between %g and %g", rng.Min, rng.Max)}
+	}
+	return nil
+}
+
+// isKnownCity reports whether measurements of the given city are accepted.
+// Depending on the config, this includes cities without measurements yet.
+func (svr *Server) isKnownCity(city string) bool {
+	// Listeners of `AllCities` would receive measurements of it twice.
+	if len(strings.TrimSpace(city)) == 0 || city == AllCities {
+		return false
+	}
+
+	if config.C.Validation.UnknownCities == config.UnknownCitiesCreate {
+		return true
+	}
+
+	if slices.Contains(config.C.Cities, city) {
+		return true
+	}
+
+	// Cities might have been created before the policy changed.
+	_, ok := svr.store.Latest(city)
+	return ok
+}
+
+// decodeError turns an error of the JSON decoder into a field error. Nested
+// fields are prefixed with `parent`.
+func decodeError(parent string, err error) FieldError {
+	field := func(name string) string {
+		if len(parent) == 0 || len(name) == 0 {
+			return parent + name
+		}
+		return parent + "." + name
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return FieldError{field(typeErr.Field), "must be of type " + jsonType(typeErr.Type.Kind().String())}
+	}
+
+	if errors.Is(err, io.EOF) {
+		return FieldError{parent, "empty body"}
+	}
+
+	// Unknown fields are only reported by message.
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return FieldError{field(strings.Trim(name, `"`)), "unknown field"}
+	}
+
+	return FieldError{parent, "malformed JSON: " + err.Error()}
+}
+
+// jsonType names the JSON type corresponding to a Go kind.
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"):
+		return "integer"
+	case strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "map", kind == "struct", kind == "ptr":
+		return "object"
+	case kind == "slice", kind == "array":
+		return "array"
+	case kind == "bool":
+		return "boolean"
+	default:
+		return kind
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/config"
+)
+
+// Ensures that all invalid fields of a measurement are reported.
+func TestParseMeasurement(t *testing.T) {
+	oldCities := config.C.Cities
+	config.C.Cities = []string{"Berlin"}
+	t.Cleanup(func() { config.C.Cities = oldCities })
+
+	now := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+	svr := &Server{store: NewMemoryStore()}
+
+	testCases := []struct {
+		name   string
+		city   string
+		body   string
+		fields []string
+	}{
+		{"valid legacy", "Berlin", `{"temp":21,"time":"2025-09-29T11:59:00Z"}`, nil},
+		{"valid", "Berlin", `{"version":2,"time":"2025-09-29T11:59:00Z","temperature":{"value":21}}`, nil},
+		{"valid with unit", "Berlin", `{"time":"2025-09-29T11:59:00Z","temperature":{"value":21,"unit":"C"}}`, nil},
+		{"empty body", "Berlin", ``, []string{""}},
+		{"no object", "Berlin", `[]`, []string{""}},
+		{"empty object", "Berlin", `{}`, []string{"time", ""}},
+		{"converted unit", "Berlin", `{"time":"2025-09-29T11:59:00Z","temperature":{"value":69.8,"unit":"F"}}`, nil},
+		{"no value", "Berlin", `{"time":"2025-09-29T11:59:00Z","humidity":{"unit":"%"}}`, []string{"humidity.value"}},
+		{"wrong unit", "Berlin", `{"time":"2025-09-29T11:59:00Z","humidity":{"value":5,"unit":"K"}}`, []string{"humidity.unit"}},
+		{"implausible value", "Berlin", `{"time":"2025-09-29T11:59:00Z","humidity":{"value":105}}`, []string{"humidity.value"}},
+		{"mixed versions", "Berlin", `{"temp":21,"time":"2025-09-29T11:59:00Z","humidity":{"value":5}}`, []string{"humidity"}},
+		{"invalid version", "Berlin", `{"version":7,"time":"2025-09-29T11:59:00Z"}`, []string{"version"}},
+		{"unknown field", "Berlin", `{"temp":21,"time":"2025-09-29T11:59:00Z","x":1}`, []string{"x"}},
+		{"wrong type", "Berlin", `{"temp":"warm","time":"2025-09-29T11:59:00Z"}`, []string{"temp"}},
+		{"unknown city", "Atlantis", `{"temp":21,"time":"2025-09-29T11:59:00Z"}`, []string{"city"}},
+		{"implausible temp", "Berlin", `{"temp":200,"time":"2025-09-29T11:59:00Z"}`, []string{"temp"}},
+		{"future time", "Berlin", `{"temp":21,"time":"2025-09-29T13:00:00Z"}`, []string{"time"}},
+		{"malformed time", "Berlin", `{"temp":21,"time":"yesterday"}`, []string{"time"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, fieldErrs := svr.parseMeasurement(tc.city, []byte(tc.body), now)
+
+			fields := []string(nil)
+			for _, fieldErr := range fieldErrs {
+				fields = append(fields, fieldErr.Field)
+			}
+			require.Equal(t, tc.fields, fields)
+
+			if len(tc.fields) == 0 {
+				require.Equal(t, MeasurementVersion, msg.Version)
+				require.NotNil(t, msg.Temperature)
+				require.InDelta(t, 21.0, msg.Temperature.Value, 1e-9)
+				require.Equal(t, UnitCelsius, msg.Temperature.Unit)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/risingwavelabs/eris"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+	probeFileName    = ".probe"
+)
+
+// Results of writes and probes are reused by `Writable()` for this long.
+const probeInterval = 5 * time.Second
+
+// walRecord is a single line of the write-ahead log. The sequence number is
+// used to skip records which are already part of the snapshot.
+type walRecord struct {
+	Seq  uint64 `json:"seq"`
+	City string `json:"city"`
+	Measurement
+}
+
+// UnmarshalJSON is required as `Measurement.UnmarshalJSON` would be promoted
+// otherwise, ignoring the other fields.
+func (rec *walRecord) UnmarshalJSON(data []byte) error {
+	var header struct {
+		Seq  uint64 `json:"seq"`
+		City string `json:"city"`
+	}
+
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return err
+	}
+
+	rec.Seq, rec.City = header.Seq, header.City
+	return json.Unmarshal(data, &rec.Measurement)
+}
+
+type snapshot struct {
+	Seq    uint64                   `json:"seq"`
+	Series map[string][]Measurement `json:"series"`
+}
+
+// logFile is the part of `*os.File` used to write the log.
+type logFile interface {
+	io.WriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// walStore is a memory store which persists all measurements. Each one is
+// appended to a log file before it is accepted. The log is periodically
+// compacted into a snapshot of the whole store.
+type walStore struct {
+	*memoryStore
+
+	// Serialises writes to the log and compaction.
+	mutex sync.Mutex
+
+	dir string
+	log logFile
+	seq uint64
+
+	// Set if a failed write could not be removed from the log. No records
+	// are appended until a compaction truncated the log.
+	broken error
+
+	// Result of the last write to the log or probe of the directory.
+	checked  time.Time
+	checkErr error
+}
+
+// openWALStore loads the snapshot and replays the log in the given directory.
+// Both are created if they do not exist yet.
+func openWALStore(dir string) (*walStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, eris.Wrapf(err, "failed to create directory '%s'", dir)
+	}
+
+	ws := &walStore{
+		memoryStore: NewMemoryStore().(*memoryStore),
+		dir:         dir,
+	}
+
+	err = ws.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ws.replayLog()
+	if err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+func (ws *walStore) Add(city string, msg Measurement) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.log == nil {
+		return eris.New("store is closed")
+	} else if ws.broken != nil {
+		return ws.broken
+	}
+
+	// Only this store writes the series, so the check stays valid.
+	ws.memoryStore.mutex.RLock()
+	_, err := ws.memoryStore.check(city, msg)
+	ws.memoryStore.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(walRecord{ws.seq + 1, city, msg})
+	if err != nil {
+		return eris.Wrap(err, "failed to marshal log record")
+	}
+
+	err = ws.write(line)
+	ws.checked, ws.checkErr = time.Now(), err
+	if err != nil {
+		return err
+	}
+
+	ws.seq++
+	return ws.memoryStore.Add(city, msg)
+}
+
+// write appends the line to the log. If that fails, the log is truncated to
+// its previous size, so neither a torn line nor a record which might not be
+// synced remains. Otherwise, the sequence number would be used twice.
+func (ws *walStore) write(line []byte) error {
+	offset, err := ws.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return eris.Wrap(err, "failed to determine end of log")
+	}
+
+	_, err = ws.log.Write(append(line, '\n'))
+	if err != nil {
+		err = eris.Wrap(err, "failed to write log record")
+	} else if err = ws.log.Sync(); err != nil {
+		err = eris.Wrap(err, "failed to sync log")
+	}
+	if err == nil {
+		return nil
+	}
+
+	rollbackErr := ws.log.Truncate(offset)
+	if rollbackErr == nil {
+		_, rollbackErr = ws.log.Seek(offset, io.SeekStart)
+	}
+	if rollbackErr != nil {
+		ws.broken = eris.Wrap(rollbackErr, "failed to remove failed write from log")
+		return errors.Join(err, ws.broken)
+	}
+
+	return err
+}
+
+// Compact writes all measurements into a new snapshot and truncates the log.
+func (ws *walStore) Compact() error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.log == nil {
+		return eris.New("store is closed")
+	}
+
+	return ws.compact()
+}
+
+// Close compacts the log a final time and closes it.
+func (ws *walStore) Close() error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.log == nil {
+		return nil
+	}
+
+	compactErr := ws.compact()
+	closeErr := ws.log.Close()
+	ws.log = nil
+
+	return errors.Join(compactErr, eris.Wrap(closeErr, "failed to close log"))
+}
+
+// Writable ensures that files can still be written to the storage directory.
+// The directory is only probed if nothing was written for a while.
+func (ws *walStore) Writable() error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.log == nil {
+		return eris.New("store is closed")
+	} else if ws.broken != nil {
+		return ws.broken
+	}
+
+	now := time.Now()
+	if now.Sub(ws.checked) < probeInterval {
+		return ws.checkErr
+	}
+
+	ws.checked, ws.checkErr = now, ws.probe()
+	return ws.checkErr
+}
+
+func (ws *walStore) probe() error {
+	path := filepath.Join(ws.dir, probeFileName)
+	err := writeFileSync(path, nil)
+	if err != nil {
+		return err
+	}
+
+	return eris.Wrap(os.Remove(path), "failed to remove probe file")
+}
+
+func (ws *walStore) compact() error {
+	ws.memoryStore.mutex.RLock()
+	data, err := json.Marshal(snapshot{ws.seq, ws.memoryStore.series})
+	ws.memoryStore.mutex.RUnlock()
+	if err != nil {
+		return eris.Wrap(err, "failed to marshal snapshot")
+	}
+
+	//
+	// Replace the snapshot atomically. Until the log is truncated, records
+	// in it are skipped based on their sequence number.
+
+	path := filepath.Join(ws.dir, snapshotFileName)
+	tmpPath := path + ".tmp"
+
+	err = writeFileSync(tmpPath, data)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return eris.Wrap(err, "failed to replace snapshot")
+	}
+
+	//
+	// Truncate log.
+
+	err = ws.log.Truncate(0)
+	if err != nil {
+		return eris.Wrap(err, "failed to truncate log")
+	}
+
+	_, err = ws.log.Seek(0, io.SeekStart)
+	if err != nil {
+		return eris.Wrap(err, "failed to rewind log")
+	}
+
+	ws.broken = nil
+	ws.checked, ws.checkErr = time.Now(), nil
+	return nil
+}
+
+func (ws *walStore) loadSnapshot() error {
+	path := filepath.Join(ws.dir, snapshotFileName)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return eris.Wrapf(err, "failed to read snapshot '%s'", path)
+	}
+
+	var snap snapshot
+	err = json.Unmarshal(data, &snap)
+	if err != nil {
+		return eris.Wrapf(err, "failed to unmarshal snapshot '%s'", path)
+	}
+
+	ws.seq = snap.Seq
+	if snap.Series != nil {
+		ws.memoryStore.series = snap.Series
+	}
+
+	return nil
+}
+
+// replayLog applies all records of the log which are newer than the snapshot
+// and opens the log for writing. An incomplete last record, e.g., after a
+// crash during a write, is removed from the log. Any other record which cannot
+// be decoded is an error and leaves the log untouched, as the records after it
+// would be lost otherwise.
+func (ws *walStore) replayLog() error {
+	path := filepath.Join(ws.dir, walFileName)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return eris.Wrapf(err, "failed to open log '%s'", path)
+	}
+
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				logger.Warn("dropping incomplete record at the end of log", "path", path)
+			}
+			break
+		} else if err != nil {
+			_ = file.Close()
+			return eris.Wrapf(err, "failed to read log '%s'", path)
+		}
+
+		var rec walRecord
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			_ = file.Close()
+			return eris.Wrapf(err, "corrupt record in log '%s' at offset %d", path, offset)
+		}
+		offset += int64(len(line))
+
+		if rec.Seq <= ws.seq {
+			continue
+		}
+		ws.seq = rec.Seq
+
+		// Logs of older versions may contain several measurements of the
+		// same time. The first one is kept.
+		err = ws.memoryStore.Add(rec.City, rec.Measurement)
+		if err != nil && !errors.Is(err, ErrDuplicate) && !errors.Is(err, ErrConflict) {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	err = file.Truncate(offset)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return eris.Wrapf(err, "failed to prepare log '%s' for writing", path)
+	}
+
+	ws.log = file
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return eris.Wrapf(err, "failed to create '%s'", path)
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+
+	err = errors.Join(err, closeErr)
+	if err != nil {
+		return eris.Wrapf(err, "failed to write '%s'", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that measurements survive a crash, i.e., reopening without a prior
+// `Close()`, even if the last record was only partially written.
+func TestWALStoreReplay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+
+	ws, err := openWALStore(dir)
+	require.NoError(t, err)
+
+	for i := range 3 {
+		err = ws.Add("Berlin", TempMessage{Temp: i, Time: base.Add(time.Duration(i) * time.Minute)}.Measurement())
+		require.NoError(t, err)
+	}
+
+	// Simulate a crash in the middle of a write.
+	_, err = ws.log.Write([]byte(`{"seq":4,"city":"Ber`))
+	require.NoError(t, err)
+	require.NoError(t, ws.log.Close())
+
+	ws, err = openWALStore(dir)
+	require.NoError(t, err)
+
+	history, ok := ws.History("Berlin", time.Time{}, time.Time{})
+	require.True(t, ok)
+	require.Len(t, history, 3)
+
+	// Retried measurements are not logged twice.
+	err = ws.Add("Berlin", TempMessage{Temp: 0, Time: base}.Measurement())
+	require.ErrorIs(t, err, ErrDuplicate)
+
+	// The torn record must not hide later ones.
+	err = ws.Add("Berlin", TempMessage{Temp: 3, Time: base.Add(3 * time.Minute)}.Measurement())
+	require.NoError(t, err)
+	require.NoError(t, ws.Close())
+
+	ws, err = openWALStore(dir)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	history, ok = ws.History("Berlin", time.Time{}, time.Time{})
+	require.True(t, ok)
+	require.Len(t, history, 4)
+}
+
+// Ensures that a corrupt record in the middle of the log fails opening the
+// store without deleting the records after it.
+func TestWALStoreCorruptRecord(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, walFileName)
+	logData := `{"seq":1,"city":"Berlin","temp":21,"time":"2025-09-29T12:00:00Z"}` + "\n" +
+		`{"seq":2,"city":"Ber` + "\n" +
+		`{"seq":3,"city":"Berlin","temp":22,"time":"2025-09-29T12:01:00Z"}` + "\n"
+
+	err := os.WriteFile(path, []byte(logData), 0o644)
+	require.NoError(t, err)
+
+	_, err = openWALStore(dir)
+	require.ErrorContains(t, err, "corrupt record")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, logData, string(data))
+}
+
+// Ensures that records which are already part of the snapshot are not applied
+// twice, e.g., if the process crashed before truncating the log.
+func TestWALStoreCompaction(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+
+	ws, err := openWALStore(dir)
+	require.NoError(t, err)
+
+	for i := range 2 {
+		err = ws.Add("Hamburg", TempMessage{Temp: i, Time: base.Add(time.Duration(i) * time.Minute)}.Measurement())
+		require.NoError(t, err)
+	}
+
+	logData, err := os.ReadFile(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+
+	require.NoError(t, ws.Compact())
+	require.NoError(t, ws.log.Close())
+
+	// Restore the log as if truncation never happened.
+	err = os.WriteFile(filepath.Join(dir, walFileName), logData, 0o644)
+	require.NoError(t, err)
+
+	ws, err = openWALStore(dir)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	history, ok := ws.History("Hamburg", time.Time{}, time.Time{})
+	require.True(t, ok)
+	require.Len(t, history, 2)
+}
+
+// Ensures that records written before the introduction of `Measurement` are
+// still replayed.
+func TestWALStoreLegacyRecords(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	legacyLog := `{"seq":1,"city":"Berlin","temp":21,"time":"2025-09-29T12:00:00Z"}` + "\n"
+
+	err := os.WriteFile(filepath.Join(dir, walFileName), []byte(legacyLog), 0o644)
+	require.NoError(t, err)
+
+	ws, err := openWALStore(dir)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	latest, ok := ws.Latest("Berlin")
+	require.True(t, ok)
+	require.Equal(t, MeasurementVersion, latest.Version)
+	require.NotNil(t, latest.Temperature)
+	require.InDelta(t, 21.0, latest.Temperature.Value, 1e-9)
+	require.Equal(t, UnitCelsius, latest.Temperature.Unit)
+}
+
+// Ensures that the storage directory is only probed if the last result is
+// outdated.
+func TestWALStoreWritable(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ws, err := openWALStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ws.Close() })
+
+	require.NoError(t, ws.Writable())
+
+	// The probe file cannot be created anymore, but the last result is reused.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, probeFileName), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, probeFileName, "file"), nil, 0o644))
+	require.NoError(t, ws.Writable())
+
+	ws.checked = ws.checked.Add(-probeInterval)
+	require.Error(t, ws.Writable())
+	require.Error(t, ws.Writable())
+
+	// Successful writes count as well.
+	require.NoError(t, ws.Add("Berlin", TempMessage{20, time.Now()}.Measurement()))
+	require.NoError(t, ws.Writable())
+}
+
+// failingLog fails writes after half of the data, syncs, or truncation.
+type failingLog struct {
+	*os.File
+	failWrite, failSync, failTruncate bool
+}
+
+func (fl *failingLog) Write(data []byte) (int, error) {
+	if fl.failWrite {
+		n, _ := fl.File.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+	return fl.File.Write(data)
+}
+
+func (fl *failingLog) Sync() error {
+	if fl.failSync {
+		return errors.New("sync failed")
+	}
+	return fl.File.Sync()
+}
+
+func (fl *failingLog) Truncate(size int64) error {
+	if fl.failTruncate {
+		return errors.New("truncate failed")
+	}
+	return fl.File.Truncate(size)
+}
+
+// Ensures that failed writes are removed from the log, so they neither tear
+// the next record nor reuse its sequence number, and that the store rejects
+// writes if that is not possible.
+func TestWALStoreFailedWrite(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base := time.Date(2025, 9, 29, 12, 0, 0, 0, time.UTC)
+	measurement := func(minutes int) Measurement {
+		return TempMessage{Temp: minutes, Time: base.Add(time.Duration(minutes) * time.Minute)}.Measurement()
+	}
+
+	ws, err := openWALStore(dir)
+	require.NoError(t, err)
+	log := &failingLog{File: ws.log.(*os.File)}
+	ws.log = log
+
+	require.NoError(t, ws.Add("Berlin", measurement(0)))
+
+	log.failWrite = true
+	require.Error(t, ws.Add("Berlin", measurement(1)))
+	log.failWrite, log.failSync = false, true
+	require.Error(t, ws.Add("Berlin", measurement(2)))
+	log.failSync = false
+	require.NoError(t, ws.Add("Berlin", measurement(3)))
+
+	// The log cannot be restored.
+	log.failWrite, log.failTruncate = true, true
+	require.Error(t, ws.Add("Berlin", measurement(4)))
+	log.failWrite, log.failTruncate = false, false
+	require.Error(t, ws.Add("Berlin", measurement(5)))
+	require.Error(t, ws.Writable())
+
+	// Compaction starts a clean log.
+	require.NoError(t, ws.Compact())
+	require.NoError(t, ws.Writable())
+	require.NoError(t, ws.Add("Berlin", measurement(6)))
+
+	// Reopening without closing replays the log as after a crash.
+	ws, err = openWALStore(dir)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	history, ok := ws.History("Berlin", time.Time{}, time.Time{})
+	require.True(t, ok)
+	require.Equal(t, []Measurement{measurement(0), measurement(3), measurement(6)}, history)
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"weather-service/internal/websocket"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+
+	// Connections are closed if the client does not answer pings.
+	wsReadTimeout  = 2 * wsPingInterval
+	wsWriteTimeout = 10 * time.Second
+
+	// How long to wait for the client to confirm the close handshake.
+	wsCloseTimeout = time.Second
+
+	wsMaxSubscriptions = 32
+)
+
+// Actions of requests sent by WebSocket clients.
+const (
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+)
+
+// Types of events sent to WebSocket clients.
+const (
+	wsMeasurement   = "measurement"
+	wsGap           = "gap"
+	wsSubscriptions = "subscriptions"
+	wsError         = "error"
+)
+
+// WSRequest changes the subscriptions of a WebSocket connection.
+type WSRequest struct {
+	Action string `json:"action"`
+	City   string `json:"city"`
+}
+
+// WSEvent is sent to WebSocket clients. Measurements carry their city; after
+// each change of the subscriptions all subscribed cities are listed. Gaps
+// announce measurements of a city which were dropped as the client did not
+// keep up.
+type WSEvent struct {
+	Type        string       `json:"type"`
+	City        string       `json:"city,omitempty"`
+	Measurement *Measurement `json:"measurement,omitempty"`
+	Missed      uint64       `json:"missed,omitempty"`
+	Cities      []string     `json:"cities,omitempty"`
+	Error       string       `json:"error,omitempty"`
+
+	// Subscription which sent the event, so events of ended ones are ignored.
+	sub uint64
+}
+
+// getCitiesNameWS streams measurements over a WebSocket. The connection starts
+// subscribed to the city of the path; clients can change that by sending
+// `WSRequest`s.
+func (svr *Server) getCitiesNameWS(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("name") == AllCities {
+		writeText(w, http.StatusBadRequest, ErrInvalidCity.Error())
+		return
+	}
+
+	units, err := parseUnitSystem(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts, err := subscribeOptions(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.After = 0
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		requestLogger(r).Debug("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	svr.sockets.Add(1)
+	defer svr.sockets.Done()
+
+	wsConnections.Inc()
+	defer wsConnections.Dec()
+
+	conn.ReadTimeout = wsReadTimeout
+	conn.WriteTimeout = wsWriteTimeout
+
+	session := &wsSession{
+		conn:   conn,
+		broker: svr.Broker,
+		units:  units,
+		opts:   opts,
+		logger: requestLogger(r),
+		events: make(chan WSEvent, 256),
+		subs:   map[string]wsSubscription{},
+	}
+	session.run(r.Context(), r.PathValue("name"))
+}
+
+type wsSession struct {
+	conn   *websocket.Conn
+	broker Broker
+	units  UnitSystem
+	logger *slog.Logger
+
+	// Options of all subscriptions. Events are not replayed.
+	opts SubscribeOptions
+
+	// Measurements of all subscriptions.
+	events chan WSEvent
+
+	// Subscription of each city and the ID of the last one. Only used by
+	// `run()`.
+	subs   map[string]wsSubscription
+	lastID uint64
+}
+
+type wsSubscription struct {
+	id     uint64
+	cancel context.CancelFunc
+}
+
+// run writes events until the client disconnects or `ctx` is done. In the
+// latter case, the connection is closed with a handshake.
+func (ws *wsSession) run(ctx context.Context, city string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	requests := make(chan WSRequest)
+	readErr := make(chan error, 1)
+	go ws.read(ctx, requests, readErr)
+
+	ws.subscribe(ctx, city)
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-ctx.Done():
+			ws.shutdown(readErr)
+			return
+
+		case err = <-readErr:
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				ws.logger.Debug("websocket closed", "error", err)
+			}
+			return
+
+		case req := <-requests:
+			err = ws.handle(ctx, req)
+
+		case event := <-ws.events:
+			if !ws.current(event) {
+				continue
+			}
+
+			// Subscriptions send errors once they ended.
+			if event.Type == wsError {
+				ws.unsubscribe(event.City)
+			}
+			err = ws.write(event)
+
+		case <-ticker.C:
+			err = ws.conn.WriteControl(websocket.PingMessage, nil)
+		}
+
+		if err != nil {
+			ws.logger.Debug("websocket write failed", "error", err)
+			return
+		}
+	}
+}
+
+// read passes the requests of the client to `run()`.
+func (ws *wsSession) read(ctx context.Context, requests chan<- WSRequest, readErr chan<- error) {
+	for {
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			readErr <- err
+			return
+		}
+
+		var req WSRequest
+		err = json.Unmarshal(data, &req)
+		if err != nil {
+			req = WSRequest{Action: "invalid"}
+		}
+
+		select {
+		case requests <- req:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ws *wsSession) handle(ctx context.Context, req WSRequest) error {
+	switch {
+	case len(req.City) == 0 && (req.Action == wsSubscribe || req.Action == wsUnsubscribe):
+		return ws.write(WSEvent{Type: wsError, Error: "missing city"})
+
+	case req.City == AllCities && req.Action == wsSubscribe:
+		return ws.write(WSEvent{Type: wsError, City: req.City, Error: ErrInvalidCity.Error()})
+
+	case req.Action == wsSubscribe:
+		if _, ok := ws.subs[req.City]; !ok && len(ws.subs) >= wsMaxSubscriptions {
+			return ws.write(WSEvent{Type: wsError, City: req.City, Error: "too many subscriptions"})
+		}
+		ws.subscribe(ctx, req.City)
+
+	case req.Action == wsUnsubscribe:
+		ws.unsubscribe(req.City)
+
+	default:
+		return ws.write(WSEvent{Type: wsError, Error: "expected {\"action\": \"subscribe\" or \"unsubscribe\", \"city\": ...}"})
+	}
+
+	return ws.write(WSEvent{Type: wsSubscriptions, Cities: slices.Sorted(maps.Keys(ws.subs))})
+}
+
+// subscribe forwards the measurements of the city to `events`.
+func (ws *wsSession) subscribe(ctx context.Context, city string) {
+	if _, ok := ws.subs[city]; ok {
+		return
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	ws.lastID++
+	id := ws.lastID
+	ws.subs[city] = wsSubscription{id, cancel}
+
+	sub := ws.broker.Listen(subCtx, city, ws.opts)
+	go func() {
+		send := func(event WSEvent) bool {
+			event.sub = id
+			select {
+			case ws.events <- event:
+				return true
+			case <-subCtx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case <-subCtx.Done():
+				return
+			case event, ok := <-sub.Events():
+				if !ok {
+					if sub.Err() != nil && subCtx.Err() == nil {
+						send(WSEvent{Type: wsError, City: city, Error: "unsubscribed: " + sub.Err().Error()})
+					}
+					return
+				}
+
+				if event.Missed > 0 && !send(WSEvent{Type: wsGap, City: event.City, Missed: event.Missed}) {
+					return
+				}
+
+				msg := ws.units.convertMeasurement(event.Measurement)
+				if !send(WSEvent{Type: wsMeasurement, City: event.City, Measurement: &msg}) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (ws *wsSession) unsubscribe(city string) {
+	if sub, ok := ws.subs[city]; ok {
+		sub.cancel()
+		delete(ws.subs, city)
+	}
+}
+
+// current reports whether the event was sent by the current subscription of
+// its city. Events of ended subscriptions might still be queued after the
+// client subscribed again.
+func (ws *wsSession) current(event WSEvent) bool {
+	sub, ok := ws.subs[event.City]
+	return ok && sub.id == event.sub
+}
+
+func (ws *wsSession) write(event WSEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return ws.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// shutdown starts the close handshake and waits for the client to confirm it.
+func (ws *wsSession) shutdown(readErr <-chan error) {
+	err := ws.conn.WriteClose(websocket.CloseGoingAway, "server shutting down")
+	if err != nil {
+		return
+	}
+
+	select {
+	case <-readErr:
+	case <-time.After(wsCloseTimeout):
+	}
+}
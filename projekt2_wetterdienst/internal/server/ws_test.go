@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"weather-service/internal/websocket"
+)
+
+// Ensures that WebSocket clients receive measurements of their subscriptions,
+// can change them, and are closed with a handshake on shutdown.
+func TestWebSocket(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	streamer := NewStreamer()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = streamer.Run(ctx)
+	}()
+
+	svr := &Server{Broker: streamer}
+	t.Cleanup(func() {
+		cancel()
+		svr.sockets.Wait()
+		<-stopped
+	})
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /cities/{name}/ws", svr.getCitiesNameWS)
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.BaseContext = func(_ net.Listener) context.Context { return ctx }
+	srv.Start()
+	defer srv.Close()
+
+	conn, _, err := websocket.Dial(context.Background(),
+		"ws"+strings.TrimPrefix(srv.URL, "http")+"/cities/WSBerlin/ws?units=kelvin", nil, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.ReadTimeout = 50 * time.Millisecond
+
+	readEvent := func() (WSEvent, error) {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return WSEvent{}, err
+		}
+
+		var event WSEvent
+		require.NoError(t, json.Unmarshal(data, &event))
+		return event, nil
+	}
+
+	// Measurements are posted until the subscription is registered.
+	receive := func(city string) WSEvent {
+		for range 100 {
+			require.NoError(t, streamer.Post(ctx, city, TempMessage{20, time.Now()}.Measurement()))
+
+			event, err := readEvent()
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			require.NoError(t, err)
+			return event
+		}
+
+		require.FailNow(t, "no measurement received")
+		return WSEvent{}
+	}
+
+	request := func(action, city string) WSEvent {
+		data, _ := json.Marshal(WSRequest{action, city})
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, data))
+
+		conn.ReadTimeout = time.Second
+		defer func() { conn.ReadTimeout = 50 * time.Millisecond }()
+
+		event, err := readEvent()
+		require.NoError(t, err)
+		return event
+	}
+
+	event := receive("WSBerlin")
+	require.Equal(t, wsMeasurement, event.Type)
+	require.Equal(t, "WSBerlin", event.City)
+	require.Equal(t, Quantity{293.15, UnitKelvin}, *event.Measurement.Temperature)
+
+	event = request(wsSubscribe, "WSHamburg")
+	require.Equal(t, WSEvent{Type: wsSubscriptions, Cities: []string{"WSBerlin", "WSHamburg"}}, event)
+
+	event = request(wsUnsubscribe, "WSBerlin")
+	require.Equal(t, WSEvent{Type: wsSubscriptions, Cities: []string{"WSHamburg"}}, event)
+
+	event = receive("WSHamburg")
+	require.Equal(t, "WSHamburg", event.City)
+
+	event = request(wsSubscribe, AllCities)
+	require.Equal(t, WSEvent{Type: wsError, City: AllCities, Error: ErrInvalidCity.Error()}, event)
+
+	event = request("dance", "")
+	require.Equal(t, wsError, event.Type)
+
+	// Shutting down closes the connection with a handshake.
+	cancel()
+	conn.ReadTimeout = time.Second
+
+	var closeErr *websocket.CloseError
+	for {
+		_, err = readEvent()
+		if err != nil {
+			break
+		}
+	}
+	require.True(t, errors.As(err, &closeErr), err)
+	require.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+}
+
+// endingBroker returns subscriptions which end with an error right away,
+// except if `keep` is set.
+type endingBroker struct {
+	postedBroker
+	keep bool
+}
+
+func (eb *endingBroker) Listen(ctx context.Context, city string, opts SubscribeOptions) Subscription {
+	sub := &subscription{ctx: ctx, msgChan: make(chan Event)}
+	if !eb.keep {
+		sub.err = errors.New("subscriber too slow")
+		close(sub.msgChan)
+	}
+	return sub
+}
+
+// Ensures that errors of ended subscriptions do not cancel newer
+// subscriptions of the same city.
+func TestWebSocketStaleEvents(t *testing.T) {
+	t.Parallel()
+
+	broker := &endingBroker{}
+	ws := &wsSession{
+		broker: broker,
+		events: make(chan WSEvent, 256),
+		subs:   map[string]wsSubscription{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	ws.subscribe(ctx, "WSBerlin")
+	event := <-ws.events
+	require.Equal(t, wsError, event.Type)
+	require.True(t, ws.current(event))
+
+	// The client subscribes again before the error is handled.
+	broker.keep = true
+	ws.unsubscribe("WSBerlin")
+	ws.subscribe(ctx, "WSBerlin")
+
+	require.False(t, ws.current(event))
+	require.Contains(t, ws.subs, "WSBerlin")
+}
@@ -5,8 +5,12 @@ import (
 	"sync"
 
 	"github.com/risingwavelabs/eris"
+
+	"weather-service/internal/logging"
 )
 
+var logger = logging.For("services")
+
 type Service interface {
 	Name() string
 	Init(ctx context.Context) error
@@ -14,18 +18,25 @@ type Service interface {
 	Stop() error
 }
 
-func Run(ctx context.Context, services []Service) error {
+// Run initialises and runs the services until one of them ends or `ctx` is
+// done. Their statuses are kept in `reg`.
+func Run(ctx context.Context, services []Service, reg *Registry) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	reg.track(services)
+
 	//
 	// Initialise services.
 
-	for _, svc := range services {
+	for i, svc := range services {
+		logger.Debug("initialising", "service", svc.Name())
 		err := svc.Init(ctx)
 		if err != nil {
+			reg.setState(i, StateFailed, err)
 			return eris.Wrapf(err, "failed to initialise %s", svc.Name())
 		}
+		reg.setState(i, StateInitialized, nil)
 	}
 
 	//
@@ -39,7 +50,15 @@ func Run(ctx context.Context, services []Service) error {
 		go func() {
 			defer wg.Done()
 			defer cancel()
+			reg.setState(i, StateRunning, nil)
 			errors[2*i] = svc.Run(ctx)
+			logger.Info("stopped running", "service", svc.Name(), "error", errors[2*i])
+
+			if errors[2*i] != nil {
+				reg.setState(i, StateFailed, errors[2*i])
+			} else {
+				reg.setState(i, StateStopped, nil)
+			}
 		}()
 	}
 
@@ -50,6 +69,7 @@ func Run(ctx context.Context, services []Service) error {
 	// Stop services (if `ctx` does not do so).
 
 	for i, svc := range services {
+		reg.setState(i, StateStopping, nil)
 		err := svc.Stop()
 		if err != nil {
 			errors[2*i+1] = eris.Wrapf(err, "failed to shut down %s", svc.Name())
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of a service run by `Run()`.
+type State string
+
+const (
+	StatePending     State = "pending"
+	StateInitialized State = "initialized"
+	StateRunning     State = "running"
+	StateStopping    State = "stopping"
+	StateStopped     State = "stopped"
+	StateFailed      State = "failed"
+)
+
+// Checker can be implemented by services to report whether they are able to
+// do their work while running.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Status of a single service.
+type Status struct {
+	Name  string    `json:"name"`
+	State State     `json:"state"`
+	Since time.Time `json:"since"`
+	Error string    `json:"error,omitempty"`
+
+	// Result of `Checker.Check()` if implemented and running.
+	Check string `json:"check,omitempty"`
+
+	ready bool
+}
+
+// Report of all services.
+type Report struct {
+	Ready    bool     `json:"ready"`
+	Services []Status `json:"services"`
+}
+
+// Registry keeps the statuses of the services started by `Run()`. The zero
+// value is ready to use.
+type Registry struct {
+	mutex    sync.Mutex
+	tracked  []Service
+	statuses []Status
+}
+
+// track resets the statuses to the given services.
+func (reg *Registry) track(services []Service) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	now := time.Now()
+	reg.tracked = services
+	reg.statuses = make([]Status, len(services))
+	for i, svc := range services {
+		reg.statuses[i] = Status{Name: svc.Name(), State: StatePending, Since: now}
+	}
+}
+
+func (reg *Registry) setState(idx int, state State, err error) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	status := &reg.statuses[idx]
+
+	// Services which ended keep their state, and stopping services do not
+	// start running anymore.
+	switch {
+	case status.State == StateStopped || status.State == StateFailed:
+		return
+	case status.State == StateStopping && state == StateRunning:
+		return
+	}
+
+	status.State = state
+	status.Since = time.Now()
+	if err != nil {
+		status.Error = err.Error()
+	}
+}
+
+// Check reports the state of all services started by `Run()`. Running
+// services implementing `Checker` are checked. The services are ready if all
+// of them are running and passed their check.
+func (reg *Registry) Check(ctx context.Context) Report {
+	reg.mutex.Lock()
+	services := reg.tracked
+	report := Report{
+		Ready:    len(reg.statuses) > 0,
+		Services: append([]Status(nil), reg.statuses...),
+	}
+	reg.mutex.Unlock()
+
+	// Checks run in parallel so one slow service does not delay the others.
+	wg := sync.WaitGroup{}
+	for i := range report.Services {
+		status := &report.Services[i]
+		status.ready = status.State == StateRunning
+
+		checker, ok := services[i].(Checker)
+		if !ok || !status.ready {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := checker.Check(ctx)
+			if err != nil {
+				status.Check = err.Error()
+				status.ready = false
+			} else {
+				status.Check = "ok"
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, status := range report.Services {
+		report.Ready = report.Ready && status.ready
+	}
+
+	return report
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeService struct {
+	name  string
+	check error
+}
+
+func (fs *fakeService) Name() string                  { return fs.name }
+func (fs *fakeService) Init(_ context.Context) error  { return nil }
+func (fs *fakeService) Stop() error                   { return nil }
+func (fs *fakeService) Check(_ context.Context) error { return fs.check }
+func (fs *fakeService) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// Ensures that services are reported as ready only while all of them run and
+// pass their checks.
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	first := &fakeService{name: "first"}
+	second := &fakeService{name: "second", check: errors.New("broken")}
+
+	var reg Registry
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Run(ctx, []Service{first, second}, &reg) }()
+
+	var report Report
+	require.Eventually(t, func() bool {
+		report = reg.Check(context.Background())
+		return len(report.Services) == 2 && report.Services[0].State == StateRunning && report.Services[1].State == StateRunning
+	}, time.Second, time.Millisecond)
+
+	require.False(t, report.Ready)
+	require.Equal(t, "broken", report.Services[1].Check)
+
+	second.check = nil
+	report = reg.Check(context.Background())
+	require.True(t, report.Ready)
+	require.Equal(t, "ok", report.Services[0].Check)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	report = reg.Check(context.Background())
+	require.False(t, report.Ready)
+	require.Equal(t, StateStopped, report.Services[0].State)
+}
@@ -3,16 +3,31 @@ package station
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"math"
 	"math/rand/v2"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
+	"weather-service/internal/auth"
 	"weather-service/internal/config"
+	"weather-service/internal/logging"
+	"weather-service/internal/metrics"
 	"weather-service/internal/server"
 )
 
+var (
+	logger = logging.For("station")
+
+	postFailures = metrics.NewCounter("weather_station_post_failures_total",
+		"Number of measurements stations failed to submit.", "city")
+)
+
 type City string
 
 func (c City) Name() string               { return string(c) }
@@ -20,6 +35,20 @@ func (City) Init(_ context.Context) error { return nil }
 func (City) Stop() error                  { return nil }
 
 func (c City) Run(ctx context.Context) error {
+	logger := logger.With("city", c.Name())
+
+	station, hasStation := config.C.StationFor(c.Name())
+
+	client, err := newClient(station)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if config.C.TLS.Enabled() {
+		scheme = "https"
+	}
+
 	time.Sleep(time.Duration(rand.IntN(1000)) * time.Millisecond)
 	ticker := time.NewTicker(time.Second)
 
@@ -31,36 +60,127 @@ func (c City) Run(ctx context.Context) error {
 		case ts = <-ticker.C:
 		}
 
-		temp := rand.IntN(35)
-		fmt.Printf("[%s] %s: %d\n", ts.UTC().Format(time.DateTime), c, temp)
+		measurement := randomMeasurement(ts)
+		logger.Debug("measured", "time", ts.UTC(), "temperature", measurement.Temperature.Value)
 
-		msg, err := json.Marshal(server.TempMessage{
-			Temp: temp,
-			Time: ts,
-		})
+		msg, err := json.Marshal(measurement)
 		if err != nil {
 			return fmt.Errorf("failed to marshall into json: %w", err)
 		}
 
-		req, err := http.NewRequestWithContext(
-			ctx, http.MethodPost,
-			fmt.Sprintf("http://localhost:%d/cities/%s", config.C.APIPort, c),
-			bytes.NewReader(msg),
-		)
-		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
+		url := fmt.Sprintf("%s://localhost:%d/cities/%s", scheme, config.C.APIPort, c)
+
+		// Throttled measurements are submitted again once the server allows
+		// it, so the station slows down instead of failing.
+		for submitted := false; !submitted; {
+			resp, err := submit(ctx, client, url, msg, station, hasStation)
+			if err != nil {
+				postFailures.Inc(c.Name())
+				return err
+			}
+			_ = resp.Body.Close()
+
+			switch resp.StatusCode {
+			case http.StatusOK:
+				submitted = true
+
+			case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+				postFailures.Inc(c.Name())
+				wait := retryAfter(resp)
+				logger.Warn("server is busy, retrying", "status", resp.StatusCode, "wait", wait)
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(wait):
+				}
+
+			default:
+				postFailures.Inc(c.Name())
+				return fmt.Errorf("failed to submit request: %v", resp)
+			}
 		}
+	}
+}
+
+// submit posts the measurement, signed if the station has a secret.
+func submit(ctx context.Context, client *http.Client, url string, msg []byte, station config.StationConfig, hasStation bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	// Stations with certificates are identified by them instead.
+	if hasStation && len(station.Secret) > 0 {
+		auth.Sign(req, msg, station.ID, []byte(station.Secret), time.Now())
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit request: %w", err)
+	}
+	return resp, nil
+}
+
+// retryAfter returns the delay requested by the `Retry-After` header in
+// seconds, or one second if there is none.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// newClient returns a client which presents the station's certificate and
+// verifies the server with its CAs, if configured.
+func newClient(station config.StationConfig) (*http.Client, error) {
+	if len(station.CertFile) == 0 && len(station.CAFile) == 0 {
+		return http.DefaultClient, nil
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
 
-		resp, err := http.DefaultClient.Do(req)
+	if len(station.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(station.CertFile, station.KeyFile)
 		if err != nil {
-			return fmt.Errorf("failed to submit request: %w", err)
-		} else if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed to submit request: %v", resp)
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
 		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
 
-		err = resp.Body.Close()
+	if len(station.CAFile) > 0 {
+		pem, err := os.ReadFile(station.CAFile)
 		if err != nil {
-			return fmt.Errorf("failed to close body: %w", err)
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA file '%s'", station.CAFile)
 		}
 	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func randomMeasurement(ts time.Time) server.Measurement {
+	// Rounds to one decimal.
+	random := func(lo, hi float64) float64 {
+		return math.Round((lo+rand.Float64()*(hi-lo))*10) / 10
+	}
+
+	return server.Measurement{
+		Version:       server.MeasurementVersion,
+		Time:          ts,
+		Temperature:   &server.Quantity{Value: random(0, 35), Unit: server.UnitCelsius},
+		Humidity:      &server.Quantity{Value: random(20, 100), Unit: server.UnitPercent},
+		Pressure:      &server.Quantity{Value: random(980, 1040), Unit: server.UnitHectopascal},
+		WindSpeed:     &server.Quantity{Value: random(0, 20), Unit: server.UnitMetersPerSecond},
+		WindDirection: &server.Quantity{Value: random(0, 360), Unit: server.UnitDegree},
+		Precipitation: &server.Quantity{Value: random(0, 5), Unit: server.UnitMillimeter},
+	}
 }
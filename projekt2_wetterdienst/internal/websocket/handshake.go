@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	noDeadline time.Time
+
+	// A deadline in the past aborts pending operations.
+	deadlineExceeded = time.Unix(1, 0)
+)
+
+// Upgrade switches the protocol of the request to WebSocket. If the request
+// is not a valid handshake, an error response is sent and an error is
+// returned.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(status int, msg string) (*Conn, error) {
+		if status == http.StatusUpgradeRequired {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+		}
+		http.Error(w, msg, status)
+		return nil, errors.New("websocket: " + msg)
+	}
+
+	switch {
+	case r.Method != http.MethodGet:
+		return fail(http.StatusMethodNotAllowed, "handshake requires GET")
+	case !headerContains(r.Header, "Connection", "upgrade"),
+		!headerContains(r.Header, "Upgrade", "websocket"):
+		return fail(http.StatusUpgradeRequired, "not a websocket handshake")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "connection cannot be hijacked")
+	}
+
+	// Deadlines set by the server must not affect the connection anymore.
+	_ = conn.SetDeadline(noDeadline)
+
+	_, err = fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: failed to complete handshake: %w", err)
+	}
+
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Dial opens a WebSocket connection to the given ws:// or wss:// URL.
+func Dial(ctx context.Context, rawURL string, header http.Header, tlsCfg *tls.Config) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: invalid URL: %w", err)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{}
+	var conn net.Conn
+
+	switch u.Scheme {
+	case "ws":
+		if len(u.Port()) == 0 {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "wss":
+		if len(u.Port()) == 0 {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsCfg}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme '%s'", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("websocket: failed to connect: %w", err)
+	}
+
+	// The handshake is aborted with `ctx`.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(deadlineExceeded) })
+	defer stop()
+
+	var keyBytes [16]byte
+	_, _ = rand.Read(keyBytes[:])
+	key := base64.StdEncoding.EncodeToString(keyBytes[:])
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    u,
+		Host:   u.Host,
+		Header: header.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	err = req.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("websocket: failed to send handshake: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("websocket: failed to read handshake: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		_ = conn.Close()
+		return nil, resp, fmt.Errorf("websocket: handshake failed with status %s", resp.Status)
+	}
+
+	if !stop() {
+		_ = conn.Close()
+		return nil, resp, ctx.Err()
+	}
+	_ = conn.SetDeadline(noDeadline)
+
+	return newConn(conn, reader, true), resp, nil
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
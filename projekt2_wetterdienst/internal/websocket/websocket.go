@@ -0,0 +1,347 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) as far as
+// needed by the service: upgrading HTTP requests, a minimal client, text and
+// binary messages, and ping, pong and close handshakes.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the opcode of a frame.
+type MessageType byte
+
+const (
+	continuation MessageType = 0x0
+
+	TextMessage   MessageType = 0x1
+	BinaryMessage MessageType = 0x2
+	CloseMessage  MessageType = 0x8
+	PingMessage   MessageType = 0x9
+	PongMessage   MessageType = 0xA
+)
+
+func (mt MessageType) isControl() bool { return mt >= CloseMessage }
+
+// Status codes of close frames.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// Control frames may not carry more data.
+const maxControlPayload = 125
+
+// GUID appended to the key of the handshake as defined by RFC 6455.
+const handshakeGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// CloseError is returned by `ReadMessage()` once the peer closed the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (ce *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d: %s", ce.Code, ce.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others write
+// concurrently.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// Clients mask their frames, servers do not.
+	client bool
+
+	// Messages exceeding this size are rejected. No limit if not positive.
+	MaxMessageSize int64
+
+	// If positive, reading fails if no frame is received for this long.
+	// Pongs count as frames, so regular pings keep the connection open.
+	ReadTimeout time.Duration
+
+	// If positive, writing a frame fails if it takes longer.
+	WriteTimeout time.Duration
+
+	writeMutex sync.Mutex
+	closeSent  bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, client bool) *Conn {
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		client:         client,
+		MaxMessageSize: 1 << 20,
+	}
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// ReadMessage returns the next text or binary message. Pings are answered and
+// close frames are confirmed while reading. Once the peer closed the
+// connection, a `*CloseError` is returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var (
+		msgType MessageType
+		message []byte
+	)
+
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch {
+		case opcode == PingMessage:
+			err = c.WriteControl(PongMessage, payload)
+			if err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, err
+			}
+			continue
+
+		case opcode == PongMessage:
+			continue
+
+		case opcode == CloseMessage:
+			return 0, nil, c.handleClose(payload)
+
+		case opcode == continuation && msgType == 0,
+			opcode != continuation && msgType != 0:
+			return 0, nil, c.fail(CloseProtocolError, "unexpected continuation")
+
+		case opcode != continuation:
+			msgType = opcode
+		}
+
+		if c.MaxMessageSize > 0 && int64(len(message)+len(payload)) > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+
+		if fin {
+			break
+		}
+	}
+
+	if msgType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+	}
+
+	return msgType, message, nil
+}
+
+func (c *Conn) readFrame() (bool, MessageType, []byte, error) {
+	if c.ReadTimeout > 0 {
+		err := c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := MessageType(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch {
+	case header[0]&0x70 != 0:
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	case opcode > BinaryMessage && !opcode.isControl(), opcode > PongMessage:
+		return false, 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+	case opcode.isControl() && (!fin || length > maxControlPayload):
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	case masked == c.client:
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid masking")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if c.MaxMessageSize > 0 && length > uint64(c.MaxMessageSize) {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(c.reader, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		maskBytes(mask, payload)
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// handleClose confirms the close frame of the peer unless the close
+// handshake was started by this side.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+	}
+
+	err := c.WriteClose(closeErr.Code, "")
+	if err != nil && !errors.Is(err, ErrCloseSent) {
+		return err
+	}
+
+	return closeErr
+}
+
+// fail sends a close frame because the peer violated the protocol.
+func (c *Conn) fail(code int, reason string) error {
+	_ = c.WriteClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *Conn) WriteMessage(msgType MessageType, data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return c.writeFrame(msgType, data)
+}
+
+// WriteControl sends a ping or pong frame.
+func (c *Conn) WriteControl(msgType MessageType, data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: control frame too large")
+	}
+	return c.WriteMessage(msgType, data)
+}
+
+// WriteClose starts or confirms the close handshake. No messages can be sent
+// afterwards. The connection must still be closed with `Close()`, ideally
+// after the peer confirmed the handshake.
+func (c *Conn) WriteClose(code int, reason string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	c.closeSent = true
+
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason[:min(len(reason), maxControlPayload-2)]...)
+	}
+
+	return c.writeFrame(CloseMessage, payload)
+}
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// writeFrame must be called with `writeMutex` locked.
+func (c *Conn) writeFrame(opcode MessageType, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|byte(opcode))
+
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+
+	if c.WriteTimeout > 0 {
+		err := c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	start := len(frame)
+	if c.client {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start += 4
+
+		frame = append(frame, payload...)
+		maskBytes(mask, frame[start:])
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + handshakeGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures that messages of all length encodings are exchanged in both
+// directions and that the close handshake is completed.
+func TestEcho(t *testing.T) {
+	t.Parallel()
+
+	serverErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+
+			err = conn.WriteMessage(msgType, data)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	conn, _, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteControl(PingMessage, []byte("ping")))
+
+	for _, size := range []int{0, 125, 126, 70_000} {
+		msg := strings.Repeat("ä", size/2)
+		require.NoError(t, conn.WriteMessage(TextMessage, []byte(msg)))
+
+		msgType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, TextMessage, msgType)
+		require.Equal(t, msg, string(data))
+	}
+
+	require.NoError(t, conn.WriteClose(CloseNormal, "bye"))
+	require.ErrorIs(t, conn.WriteMessage(TextMessage, []byte("late")), ErrCloseSent)
+
+	// The server confirms the close frame.
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.True(t, errors.As(err, &closeErr))
+	require.Equal(t, CloseNormal, closeErr.Code)
+
+	err = <-serverErr
+	require.True(t, errors.As(err, &closeErr))
+	require.Equal(t, CloseNormal, closeErr.Code)
+	require.Equal(t, "bye", closeErr.Reason)
+}
+
+// Ensures that requests which are no valid handshake are rejected.
+func TestUpgradeRejects(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	_, err := Upgrade(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Error(t, err)
+	require.Equal(t, http.StatusUpgradeRequired, rec.Code)
+	require.Equal(t, "13", rec.Header().Get("Sec-WebSocket-Version"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "short")
+
+	rec = httptest.NewRecorder()
+	_, err = Upgrade(rec, req)
+	require.Error(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}